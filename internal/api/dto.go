@@ -1,6 +1,53 @@
 package api
 
-import "jobProject/internal/model"
+import (
+	"jobProject/internal/conv"
+	"jobProject/internal/model"
+	"time"
+)
+
+// SubscriptionResponse is the wire shape of a stored subscription, it mirrors
+// model.Subscription so a read can be sent back as a patch
+type SubscriptionResponse struct {
+	ID        int     `json:"id"`
+	Service   string  `json:"service"`
+	Price     int     `json:"price"`
+	UserID    string  `json:"user_id"`
+	StartDate string  `json:"start_date"`
+	EndDate   *string `json:"end_date,omitempty"`
+	// PausedFrom is set while the subscription is paused
+	PausedFrom *string `json:"paused_from,omitempty"`
+	// DeletedAt is set only on soft-deleted subscriptions read with include_deleted
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+func NewSubscriptionResponse(s model.SubscriptionDB) SubscriptionResponse {
+	resp := SubscriptionResponse{
+		ID:        s.ID,
+		Service:   s.Service,
+		Price:     s.Price,
+		UserID:    s.UserID,
+		StartDate: conv.FormatMMYYYY(s.StartDate),
+		DeletedAt: s.DeletedAt,
+	}
+	if s.EndDate != nil {
+		end := conv.FormatMMYYYY(*s.EndDate)
+		resp.EndDate = &end
+	}
+	if s.PausedFrom != nil {
+		paused := conv.FormatMMYYYY(*s.PausedFrom)
+		resp.PausedFrom = &paused
+	}
+	return resp
+}
+
+func NewSubscriptionList(subs []model.SubscriptionDB) []SubscriptionResponse {
+	list := make([]SubscriptionResponse, 0, len(subs))
+	for _, s := range subs {
+		list = append(list, NewSubscriptionResponse(s))
+	}
+	return list
+}
 
 type PaginationParams struct {
 	Page  int
@@ -33,6 +80,95 @@ type PaginationMeta struct {
 }
 
 type PaginatedResponse struct {
-	Data       []model.SubscriptionDB `json:"data"`
+	Data       []SubscriptionResponse `json:"data"`
 	Pagination PaginationMeta         `json:"pagination"`
 }
+
+type HistoryEntryResponse struct {
+	ID             int64                    `json:"id"`
+	SubscriptionID int                      `json:"subscription_id"`
+	Action         string                   `json:"action"`
+	OldValues      *model.SubscriptionState `json:"old_values,omitempty"`
+	NewValues      *model.SubscriptionState `json:"new_values,omitempty"`
+	Actor          string                   `json:"actor"`
+	RequestID      string                   `json:"request_id,omitempty"`
+	Reason         string                   `json:"reason,omitempty"`
+	ChangedAt      time.Time                `json:"changed_at"`
+}
+
+func NewHistoryList(entries []model.HistoryEntry) []HistoryEntryResponse {
+	list := make([]HistoryEntryResponse, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, HistoryEntryResponse{
+			ID:             e.ID,
+			SubscriptionID: e.SubscriptionID,
+			Action:         e.Action,
+			OldValues:      e.OldValues,
+			NewValues:      e.NewValues,
+			Actor:          e.Actor,
+			RequestID:      e.RequestID,
+			Reason:         e.Reason,
+			ChangedAt:      e.ChangedAt,
+		})
+	}
+	return list
+}
+
+type HistoryResponse struct {
+	Data       []HistoryEntryResponse `json:"data"`
+	Pagination PaginationMeta         `json:"pagination"`
+}
+
+// CancelRequest ends a subscription, EndDate is MM-YYYY and defaults to the current month
+type CancelRequest struct {
+	EndDate *string `json:"end_date,omitempty"`
+	Reason  string  `json:"reason,omitempty"`
+}
+
+// PauseRequest stops billing from the MM-YYYY month From, the current month by default
+type PauseRequest struct {
+	From   *string `json:"from,omitempty"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+type ResumeRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// RenewRequest moves end_date Months later
+type RenewRequest struct {
+	Months int    `json:"months"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type TotalPriceResponse struct {
+	Total     int                    `json:"total"`
+	Breakdown []model.PriceBreakdown `json:"breakdown,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ErrorBody struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ComponentStatus struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Pending *int   `json:"pending,omitempty"`
+}
+
+type HealthResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// apiKeyEntry is one key of the API keys file. Only the sha256 of the key is
+// stored, generate it with `printf %s "$KEY" | sha256sum`
+type apiKeyEntry struct {
+	Name    string `yaml:"name"`
+	SHA256  string `yaml:"sha256"`
+	Subject string `yaml:"subject"`
+	Role    string `yaml:"role"`
+}
+
+func loadAPIKeys(path, adminRole string) (map[string]Principal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api keys file: %w", err)
+	}
+	var entries []apiKeyEntry
+	if err := yaml.UnmarshalStrict(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse api keys file %s: %w", path, err)
+	}
+
+	keys := make(map[string]Principal, len(entries))
+	for i, e := range entries {
+		hash := strings.ToLower(strings.TrimSpace(e.SHA256))
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("api key %d (%s): sha256 must be 64 hex chars", i, e.Name)
+		}
+		if e.Name == "" {
+			return nil, fmt.Errorf("api key %d: name is required", i)
+		}
+		admin := adminRole != "" && e.Role == adminRole
+		if !admin && !isUserID(e.Subject) {
+			return nil, fmt.Errorf("api key %s: subject must be a user UUID unless the role is %s", e.Name, adminRole)
+		}
+		if _, dup := keys[hash]; dup {
+			return nil, fmt.Errorf("api key %s: duplicate sha256", e.Name)
+		}
+		keys[hash] = Principal{
+			Subject: e.Subject,
+			Name:    e.Name,
+			Admin:   admin,
+			Method:  MethodAPIKey,
+		}
+	}
+	return keys, nil
+}
+
+func (a *Authenticator) apiKey(key string) (Principal, error) {
+	if a.apiKeys == nil {
+		return Principal{}, errors.Join(ErrInvalidCredentials, errors.New("api keys are not enabled"))
+	}
+	sum := sha256.Sum256([]byte(key))
+	p, ok := a.apiKeys[hex.EncodeToString(sum[:])]
+	if !ok {
+		return Principal{}, errors.Join(ErrInvalidCredentials, errors.New("unknown api key"))
+	}
+	return p, nil
+}
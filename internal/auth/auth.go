@@ -0,0 +1,179 @@
+// Package auth authenticates callers by static API key or JWT and carries the
+// resulting principal through the request context.
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"jobProject/internal/config"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	APIKeyHeader = "X-API-Key"
+
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+var (
+	ErrMissingCredentials = errors.New("missing credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is the authenticated caller. Subject is the user_id whose
+// subscriptions it may access, Admin lifts that restriction
+type Principal struct {
+	Subject string
+	// Name identifies the caller in logs, the key name for API keys and the subject for tokens
+	Name   string
+	Admin  bool
+	Method string
+}
+
+type ctxKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(Principal)
+	return p, ok
+}
+
+// Owner returns the user_id the caller is confined to, empty when the caller
+// is an admin or authentication is disabled
+func Owner(ctx context.Context) string {
+	p, ok := FromContext(ctx)
+	if !ok || p.Admin {
+		return ""
+	}
+	return p.Subject
+}
+
+var userIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// isUserID reports whether subject has the lowercase UUID form of subs_table.user_id
+func isUserID(subject string) bool {
+	return userIDPattern.MatchString(subject)
+}
+
+type Authenticator struct {
+	apiKeys    map[string]Principal
+	hmacSecret []byte
+	rsaKey     *rsa.PublicKey
+	issuer     string
+	audience   string
+	adminRole  string
+	now        func() time.Time
+}
+
+// New loads the key material referenced by cfg
+func New(cfg config.AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		issuer:    cfg.JWTIssuer,
+		audience:  cfg.JWTAudience,
+		adminRole: cfg.AdminRole,
+		now:       time.Now,
+	}
+
+	if cfg.APIKeysFile != "" {
+		keys, err := loadAPIKeys(cfg.APIKeysFile, cfg.AdminRole)
+		if err != nil {
+			return nil, err
+		}
+		a.apiKeys = keys
+	}
+
+	if cfg.JWTSecretFile != "" {
+		secret, err := os.ReadFile(cfg.JWTSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read jwt secret: %w", err)
+		}
+		secret = []byte(strings.TrimSpace(string(secret)))
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("jwt secret must be at least 32 bytes")
+		}
+		a.hmacSecret = secret
+	}
+
+	if cfg.JWTPublicKeyFile != "" {
+		key, err := loadRSAPublicKey(cfg.JWTPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		a.rsaKey = key
+	}
+
+	return a, nil
+}
+
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwt public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt public key %s is not PEM encoded", path)
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse jwt public key: %w", err)
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("jwt public key %s is not an RSA key", path)
+		}
+		return rsaKey, nil
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse jwt public key: %w", err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q in %s", block.Type, path)
+	}
+}
+
+// Authenticate reads the X-API-Key header, falling back to an Authorization bearer token
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return a.apiKey(key)
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return Principal{}, ErrMissingCredentials
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Principal{}, errors.Join(ErrInvalidCredentials, errors.New("authorization scheme must be Bearer"))
+	}
+	return a.jwt(strings.TrimSpace(token))
+}
+
+func (a *Authenticator) isAdmin(roles []string) bool {
+	if a.adminRole == "" {
+		return false
+	}
+	for _, role := range roles {
+		if role == a.adminRole {
+			return true
+		}
+	}
+	return false
+}
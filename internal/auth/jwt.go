@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// clockSkew tolerates small clock differences with the token issuer
+const clockSkew = time.Minute
+
+// stringList decodes a claim that may be either a string or an array of strings
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*l = many
+	return nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+type jwtClaims struct {
+	Subject   string     `json:"sub"`
+	Issuer    string     `json:"iss"`
+	Audience  stringList `json:"aud"`
+	ExpiresAt *float64   `json:"exp"`
+	NotBefore *float64   `json:"nbf"`
+	Role      string     `json:"role"`
+	Roles     []string   `json:"roles"`
+}
+
+func invalidToken(format string, args ...any) error {
+	return errors.Join(ErrInvalidCredentials, fmt.Errorf("invalid token: "+format, args...))
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jwt verifies a compact HS256 or RS256 token. The algorithm is only accepted
+// when its key is configured, so an RSA public key can never be used as an HMAC secret
+func (a *Authenticator) jwt(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, invalidToken("want header.payload.signature")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Principal{}, invalidToken("malformed header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, invalidToken("malformed signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "HS256":
+		if a.hmacSecret == nil {
+			return Principal{}, invalidToken("HS256 is not enabled")
+		}
+		mac := hmac.New(sha256.New, a.hmacSecret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return Principal{}, invalidToken("bad signature")
+		}
+	case "RS256":
+		if a.rsaKey == nil {
+			return Principal{}, invalidToken("RS256 is not enabled")
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(a.rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return Principal{}, invalidToken("bad signature")
+		}
+	default:
+		return Principal{}, invalidToken("unsupported alg %q", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Principal{}, invalidToken("malformed claims")
+	}
+	if err := a.validateClaims(claims); err != nil {
+		return Principal{}, err
+	}
+
+	roles := claims.Roles
+	if claims.Role != "" {
+		roles = append(roles, claims.Role)
+	}
+	admin := a.isAdmin(roles)
+	// the subject of a user is compared with the user_id of the rows it may access
+	if !admin && !isUserID(claims.Subject) {
+		return Principal{}, invalidToken("sub of a user must be a UUID")
+	}
+	return Principal{
+		Subject: claims.Subject,
+		Name:    claims.Subject,
+		Admin:   admin,
+		Method:  MethodJWT,
+	}, nil
+}
+
+func (a *Authenticator) validateClaims(c jwtClaims) error {
+	now := a.now()
+	if c.ExpiresAt == nil {
+		return invalidToken("exp is required")
+	}
+	if now.After(time.Unix(int64(*c.ExpiresAt), 0).Add(clockSkew)) {
+		return invalidToken("token expired")
+	}
+	if c.NotBefore != nil && now.Add(clockSkew).Before(time.Unix(int64(*c.NotBefore), 0)) {
+		return invalidToken("token not valid yet")
+	}
+	if c.Subject == "" {
+		return invalidToken("sub is required")
+	}
+	if a.issuer != "" && c.Issuer != a.issuer {
+		return invalidToken("unexpected issuer %q", c.Issuer)
+	}
+	if a.audience != "" {
+		found := false
+		for _, aud := range c.Audience {
+			if aud == a.audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return invalidToken("token is not issued for %q", a.audience)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var (
+	testNow    = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	testSecret = []byte("0123456789abcdef0123456789abcdef")
+)
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func segment(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(t *testing.T, secret []byte, claims map[string]any) string {
+	t.Helper()
+	unsigned := segment(t, map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + segment(t, claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+	unsigned := segment(t, map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + segment(t, claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// claims are valid at testNow unless overridden
+func claims(overrides map[string]any) map[string]any {
+	c := map[string]any{
+		"sub": "70601fee-2bf1-4721-ae6f-7636e79a0cbb",
+		"exp": testNow.Add(time.Hour).Unix(),
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(c, k)
+			continue
+		}
+		c[k] = v
+	}
+	return c
+}
+
+func newTestAuthenticator(secret []byte, key *rsa.PublicKey) *Authenticator {
+	return &Authenticator{
+		hmacSecret: secret,
+		rsaKey:     key,
+		adminRole:  "admin",
+		now:        func() time.Time { return testNow },
+	}
+}
+
+func TestJWTAlgorithms(t *testing.T) {
+	key := testRSAKey(t)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	der := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+
+	rsaOnly := newTestAuthenticator(nil, &key.PublicKey)
+	hmacOnly := newTestAuthenticator(testSecret, nil)
+	both := newTestAuthenticator(testSecret, &key.PublicKey)
+
+	unsignedNone := segment(t, map[string]string{"alg": "none"}) + "." + segment(t, claims(nil)) + "."
+
+	tests := []struct {
+		name  string
+		a     *Authenticator
+		token string
+		ok    bool
+	}{
+		{"RS256 with the RSA key", rsaOnly, signRS256(t, key, claims(nil)), true},
+		{"HS256 with the secret", hmacOnly, signHS256(t, testSecret, claims(nil)), true},
+		{"both algorithms enabled, RS256", both, signRS256(t, key, claims(nil)), true},
+		{"both algorithms enabled, HS256", both, signHS256(t, testSecret, claims(nil)), true},
+		// alg confusion: the public key is no secret, it must never verify an HMAC
+		{"HS256 keyed with the PEM public key, only RSA configured", rsaOnly, signHS256(t, pemKey, claims(nil)), false},
+		{"HS256 keyed with the DER public key, only RSA configured", rsaOnly, signHS256(t, der, claims(nil)), false},
+		{"HS256 keyed with the PEM public key, both configured", both, signHS256(t, pemKey, claims(nil)), false},
+		{"RS256 when only HS256 configured", hmacOnly, signRS256(t, key, claims(nil)), false},
+		{"HS256 with another secret", hmacOnly, signHS256(t, []byte("another secret of at least 32 bytes!"), claims(nil)), false},
+		{"alg none", both, unsignedNone, false},
+		{"two segments", both, "a.b", false},
+		{"garbage signature", both, signHS256(t, testSecret, claims(nil)) + "!", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.a.jwt(tt.token)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if p.Method != MethodJWT || p.Subject != "70601fee-2bf1-4721-ae6f-7636e79a0cbb" {
+					t.Errorf("unexpected principal %+v", p)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("want ErrInvalidCredentials, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateClaims(t *testing.T) {
+	unix := func(d time.Duration) int64 { return testNow.Add(d).Unix() }
+
+	tests := []struct {
+		name     string
+		issuer   string
+		audience string
+		claims   map[string]any
+		ok       bool
+	}{
+		{name: "valid", claims: claims(nil), ok: true},
+		{name: "exp missing", claims: claims(map[string]any{"exp": nil})},
+		{name: "expired within the skew", claims: claims(map[string]any{"exp": unix(-30 * time.Second)}), ok: true},
+		{name: "expired beyond the skew", claims: claims(map[string]any{"exp": unix(-clockSkew - time.Second)})},
+		{name: "nbf within the skew", claims: claims(map[string]any{"nbf": unix(30 * time.Second)}), ok: true},
+		{name: "nbf beyond the skew", claims: claims(map[string]any{"nbf": unix(clockSkew + time.Second)})},
+		{name: "nbf in the past", claims: claims(map[string]any{"nbf": unix(-time.Hour)}), ok: true},
+		{name: "sub missing", claims: claims(map[string]any{"sub": nil})},
+		{name: "user sub is not a UUID", claims: claims(map[string]any{"sub": "alice"})},
+		{name: "user sub in upper case", claims: claims(map[string]any{"sub": "70601FEE-2BF1-4721-AE6F-7636E79A0CBB"})},
+		{name: "admin sub need not be a UUID", claims: claims(map[string]any{"sub": "ops", "role": "admin"}), ok: true},
+		{name: "issuer matches", issuer: "https://issuer", claims: claims(map[string]any{"iss": "https://issuer"}), ok: true},
+		{name: "issuer differs", issuer: "https://issuer", claims: claims(map[string]any{"iss": "https://other"})},
+		{name: "issuer missing", issuer: "https://issuer", claims: claims(nil)},
+		{name: "aud as string", audience: "subs", claims: claims(map[string]any{"aud": "subs"}), ok: true},
+		{name: "aud as array", audience: "subs", claims: claims(map[string]any{"aud": []string{"billing", "subs"}}), ok: true},
+		{name: "aud string differs", audience: "subs", claims: claims(map[string]any{"aud": "billing"})},
+		{name: "aud array without it", audience: "subs", claims: claims(map[string]any{"aud": []string{"billing"}})},
+		{name: "aud missing", audience: "subs", claims: claims(nil)},
+		{name: "aud not checked when unset", claims: claims(map[string]any{"aud": "anything"}), ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAuthenticator(testSecret, nil)
+			a.issuer = tt.issuer
+			a.audience = tt.audience
+
+			_, err := a.jwt(signHS256(t, testSecret, tt.claims))
+			if tt.ok && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("want ErrInvalidCredentials, got %v", err)
+			}
+		})
+	}
+}
+
+func TestJWTOwner(t *testing.T) {
+	a := newTestAuthenticator(testSecret, nil)
+	const subject = "70601fee-2bf1-4721-ae6f-7636e79a0cbb"
+
+	tests := []struct {
+		name      string
+		claims    map[string]any
+		wantOwner string
+	}{
+		{"user is confined to its own rows", claims(nil), subject},
+		{"other role is confined too", claims(map[string]any{"role": "support"}), subject},
+		{"admin role", claims(map[string]any{"role": "admin"}), ""},
+		{"admin among roles", claims(map[string]any{"roles": []string{"support", "admin"}}), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/subscriptions", nil)
+			r.Header.Set("Authorization", "Bearer "+signHS256(t, testSecret, tt.claims))
+			p, err := a.Authenticate(r)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := Owner(WithPrincipal(context.Background(), p)); got != tt.wantOwner {
+				t.Errorf("Owner() = %q, want %q", got, tt.wantOwner)
+			}
+		})
+	}
+
+	if got := Owner(context.Background()); got != "" {
+		t.Errorf("Owner() without a principal = %q, want empty", got)
+	}
+}
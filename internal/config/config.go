@@ -1,58 +1,214 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DBconfig
-	Logging  LogConfig
+	Server        ServerConfig        `yaml:"server"`
+	Database      DBconfig            `yaml:"database"`
+	Logging       LogConfig           `yaml:"logging"`
+	Subscriptions SubscriptionsConfig `yaml:"subscriptions"`
+	Tracing       TracingConfig       `yaml:"tracing"`
+	Auth          AuthConfig          `yaml:"auth"`
+	RateLimit     RateLimitConfig     `yaml:"rate_limit"`
 }
 
 type ServerConfig struct {
-	Port            string
-	ReadTimeout     time.Duration
-	WriteTimeout    time.Duration
-	ShutdownTimeout time.Duration
+	Port            string        `yaml:"port"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ShutdownDrain is how long the server keeps serving after readiness turns false on shutdown,
+	// so load balancers stop routing to it before connections are closed
+	ShutdownDrain time.Duration `yaml:"shutdown_drain"`
 }
 
 type DBconfig struct {
-	Host     string
-	Port     string
-	User     string
-	Password string
-	DBName   string
-	SSLMode  string
+	Host        string `yaml:"host"`
+	Port        string `yaml:"port"`
+	User        string `yaml:"user"`
+	Password    string `yaml:"password"`
+	DBName      string `yaml:"dbname"`
+	SSLMode     string `yaml:"sslmode"`
+	AutoMigrate bool   `yaml:"auto_migrate"`
 }
 
 type LogConfig struct {
-	Level string
+	Level string `yaml:"level"`
+}
+
+type SubscriptionsConfig struct {
+	OverlapPolicy string `yaml:"overlap_policy"`
+	// DeletedRetention is how long soft-deleted subscriptions can be restored, 0 keeps them forever
+	DeletedRetention time.Duration `yaml:"deleted_retention"`
+	// PurgeInterval is how often subscriptions past the retention and expired idempotency keys are deleted
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+	// IdempotencyTTL is how long an Idempotency-Key replays the original response, 0 keeps keys forever
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl"`
+}
+
+type TracingConfig struct {
+	// Exporter is off, stdout or otlp
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the OTLP/HTTP collector URL, OTEL_EXPORTER_OTLP_* variables apply when empty
+	Endpoint string `yaml:"endpoint"`
+	// File redirects the stdout exporter into a file
+	File        string  `yaml:"file"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// APIKeysFile is a YAML list of sha256 key hashes with the subject and role each key acts as
+	APIKeysFile string `yaml:"api_keys_file"`
+	// JWTSecretFile holds the shared HS256 secret
+	JWTSecretFile string `yaml:"jwt_secret_file"`
+	// JWTPublicKeyFile holds the PEM encoded RSA public key verifying RS256 tokens
+	JWTPublicKeyFile string `yaml:"jwt_public_key_file"`
+	// JWTIssuer and JWTAudience are checked against iss and aud when set
+	JWTIssuer   string `yaml:"jwt_issuer"`
+	JWTAudience string `yaml:"jwt_audience"`
+	// AdminRole lets a caller access every user's subscriptions
+	AdminRole string `yaml:"admin_role"`
+}
+
+// RateLimit is a token bucket refilled with Rate tokens per second holding at most Burst
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
 }
 
-func LoadConfig() (*Config, error) {
-	cfg := &Config{
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Read applies to GET and HEAD requests, Write to every other method
+	Read  RateLimit `yaml:"read"`
+	Write RateLimit `yaml:"write"`
+	// Routes overrides the limit of single mux patterns, e.g. "GET /api/v1/subscriptions"
+	Routes map[string]RateLimit `yaml:"routes"`
+	// AuthFailures limits missing or invalid credentials per client IP, checked before authenticating
+	AuthFailures RateLimit `yaml:"auth_failures"`
+	// TrustProxy takes the client IP from X-Forwarded-For instead of the connection
+	TrustProxy bool `yaml:"trust_proxy"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
 		Server: ServerConfig{
-			Port:            getEnv("SERVER_PORT", "8080"),
-			ReadTimeout:     getDurationEnv("SERVER_READ_TIMEOUT", 15*time.Second),
-			WriteTimeout:    getDurationEnv("SERVER_WRITE_TIMEOUT", 15*time.Second),
-			ShutdownTimeout: getDurationEnv("SERVER_SHUTDOWN_TIMEOUT", 10*time.Second),
+			Port:            "8080",
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+			ShutdownDrain:   5 * time.Second,
 		},
 		Database: DBconfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getEnv("DB_PORT", "5432"),
-			User:     getEnv("DB_USER", "postgres"),
-			Password: getEnv("DB_PASSWORD", "password"),
-			DBName:   getEnv("DB_NAME", "subscriptions"),
-			SSLMode:  getEnv("DB_SSLMODE", "disable"),
+			Host:        "localhost",
+			Port:        "5432",
+			User:        "postgres",
+			Password:    "password",
+			DBName:      "subscriptions",
+			SSLMode:     "disable",
+			AutoMigrate: true,
 		},
 		Logging: LogConfig{
-			Level: getEnv("LOG_LEVEL", "info"),
+			Level: "info",
+		},
+		Subscriptions: SubscriptionsConfig{
+			OverlapPolicy:    "reject",
+			DeletedRetention: 30 * 24 * time.Hour,
+			PurgeInterval:    time.Hour,
+			IdempotencyTTL:   24 * time.Hour,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "off",
+			ServiceName: "subs-app",
+			SampleRatio: 1,
+		},
+		Auth: AuthConfig{
+			AdminRole: "admin",
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Read:    RateLimit{Rate: 20, Burst: 40},
+			Write:   RateLimit{Rate: 5, Burst: 10},
+			// ten failures at once, then one every five seconds
+			AuthFailures: RateLimit{Rate: 0.2, Burst: 10},
 		},
 	}
+}
+
+// LoadConfig builds the configuration from defaults, then the optional YAML file at path,
+// then environment variables, each layer overriding the previous one
+func LoadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	env := &envParser{}
+	cfg.Server.Port = getEnv("SERVER_PORT", cfg.Server.Port)
+	cfg.Server.ReadTimeout = env.getDurationEnv("SERVER_READ_TIMEOUT", cfg.Server.ReadTimeout)
+	cfg.Server.WriteTimeout = env.getDurationEnv("SERVER_WRITE_TIMEOUT", cfg.Server.WriteTimeout)
+	cfg.Server.ShutdownTimeout = env.getDurationEnv("SERVER_SHUTDOWN_TIMEOUT", cfg.Server.ShutdownTimeout)
+	cfg.Server.ShutdownDrain = env.getDurationEnv("SERVER_SHUTDOWN_DRAIN", cfg.Server.ShutdownDrain)
+
+	cfg.Database.Host = getEnv("DB_HOST", cfg.Database.Host)
+	cfg.Database.Port = getEnv("DB_PORT", cfg.Database.Port)
+	cfg.Database.User = getEnv("DB_USER", cfg.Database.User)
+	cfg.Database.Password = getEnv("DB_PASSWORD", cfg.Database.Password)
+	cfg.Database.DBName = getEnv("DB_NAME", cfg.Database.DBName)
+	cfg.Database.SSLMode = getEnv("DB_SSLMODE", cfg.Database.SSLMode)
+	cfg.Database.AutoMigrate = env.getBoolEnv("DB_AUTO_MIGRATE", cfg.Database.AutoMigrate)
+
+	cfg.Logging.Level = strings.ToLower(getEnv("LOG_LEVEL", cfg.Logging.Level))
+
+	cfg.Subscriptions.OverlapPolicy = getEnv("SUBS_OVERLAP_POLICY", cfg.Subscriptions.OverlapPolicy)
+	cfg.Subscriptions.DeletedRetention = env.getDurationEnv("SUBS_DELETED_RETENTION", cfg.Subscriptions.DeletedRetention)
+	cfg.Subscriptions.PurgeInterval = env.getDurationEnv("SUBS_PURGE_INTERVAL", cfg.Subscriptions.PurgeInterval)
+	cfg.Subscriptions.IdempotencyTTL = env.getDurationEnv("SUBS_IDEMPOTENCY_TTL", cfg.Subscriptions.IdempotencyTTL)
+
+	cfg.Tracing.Exporter = getEnv("TRACING_EXPORTER", cfg.Tracing.Exporter)
+	cfg.Tracing.Endpoint = getEnv("TRACING_ENDPOINT", cfg.Tracing.Endpoint)
+	cfg.Tracing.File = getEnv("TRACING_FILE", cfg.Tracing.File)
+	cfg.Tracing.ServiceName = getEnv("TRACING_SERVICE_NAME", cfg.Tracing.ServiceName)
+	cfg.Tracing.SampleRatio = env.getFloatEnv("TRACING_SAMPLE_RATIO", cfg.Tracing.SampleRatio)
+
+	cfg.Auth.Enabled = env.getBoolEnv("AUTH_ENABLED", cfg.Auth.Enabled)
+	cfg.Auth.APIKeysFile = getEnv("AUTH_API_KEYS_FILE", cfg.Auth.APIKeysFile)
+	cfg.Auth.JWTSecretFile = getEnv("AUTH_JWT_SECRET_FILE", cfg.Auth.JWTSecretFile)
+	cfg.Auth.JWTPublicKeyFile = getEnv("AUTH_JWT_PUBLIC_KEY_FILE", cfg.Auth.JWTPublicKeyFile)
+	cfg.Auth.JWTIssuer = getEnv("AUTH_JWT_ISSUER", cfg.Auth.JWTIssuer)
+	cfg.Auth.JWTAudience = getEnv("AUTH_JWT_AUDIENCE", cfg.Auth.JWTAudience)
+	cfg.Auth.AdminRole = getEnv("AUTH_ADMIN_ROLE", cfg.Auth.AdminRole)
+
+	cfg.RateLimit.Enabled = env.getBoolEnv("RATE_LIMIT_ENABLED", cfg.RateLimit.Enabled)
+	cfg.RateLimit.Read.Rate = env.getFloatEnv("RATE_LIMIT_READ_RATE", cfg.RateLimit.Read.Rate)
+	cfg.RateLimit.Read.Burst = env.getIntEnv("RATE_LIMIT_READ_BURST", cfg.RateLimit.Read.Burst)
+	cfg.RateLimit.Write.Rate = env.getFloatEnv("RATE_LIMIT_WRITE_RATE", cfg.RateLimit.Write.Rate)
+	cfg.RateLimit.Write.Burst = env.getIntEnv("RATE_LIMIT_WRITE_BURST", cfg.RateLimit.Write.Burst)
+	cfg.RateLimit.AuthFailures.Rate = env.getFloatEnv("RATE_LIMIT_AUTH_FAILURE_RATE", cfg.RateLimit.AuthFailures.Rate)
+	cfg.RateLimit.AuthFailures.Burst = env.getIntEnv("RATE_LIMIT_AUTH_FAILURE_BURST", cfg.RateLimit.AuthFailures.Burst)
+	cfg.RateLimit.TrustProxy = env.getBoolEnv("RATE_LIMIT_TRUST_PROXY", cfg.RateLimit.TrustProxy)
+
+	if err := env.err(); err != nil {
+		return nil, fmt.Errorf("failed to read environment: %w", err)
+	}
+
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
 	}
@@ -60,11 +216,23 @@ func LoadConfig() (*Config, error) {
 	return cfg, nil
 }
 
+// Redacted returns a copy safe to print, with secrets masked
+func (c Config) Redacted() Config {
+	if c.Database.Password != "" {
+		c.Database.Password = "******"
+	}
+	return c
+}
+
 func (c *Config) Validate() error {
 	if c.Server.Port == "" {
 		return fmt.Errorf("server port is required")
 	}
 
+	if c.Server.ShutdownDrain < 0 {
+		return fmt.Errorf("invalid shutdown drain: %v (must not be negative)", c.Server.ShutdownDrain)
+	}
+
 	if c.Database.Host == "" {
 		return fmt.Errorf("database host is required")
 	}
@@ -83,8 +251,57 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid log level: %s (must be debug, info, warn, or error)", c.Logging.Level)
 	}
 
+	if c.Subscriptions.DeletedRetention < 0 {
+		return fmt.Errorf("invalid deleted retention: %v (must not be negative)", c.Subscriptions.DeletedRetention)
+	}
+	if c.Subscriptions.IdempotencyTTL < 0 {
+		return fmt.Errorf("invalid idempotency ttl: %v (must not be negative)", c.Subscriptions.IdempotencyTTL)
+	}
+	if (c.Subscriptions.DeletedRetention > 0 || c.Subscriptions.IdempotencyTTL > 0) && c.Subscriptions.PurgeInterval <= 0 {
+		return fmt.Errorf("invalid purge interval: %v (must be positive)", c.Subscriptions.PurgeInterval)
+	}
+
+	switch c.Tracing.Exporter {
+	case "off", "stdout", "otlp":
+	default:
+		return fmt.Errorf("invalid tracing exporter: %s (must be off, stdout, or otlp)", c.Tracing.Exporter)
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("invalid tracing sample ratio: %v (must be between 0 and 1)", c.Tracing.SampleRatio)
+	}
+
+	if c.Auth.Enabled && c.Auth.APIKeysFile == "" && c.Auth.JWTSecretFile == "" && c.Auth.JWTPublicKeyFile == "" {
+		return fmt.Errorf("auth is enabled but no api keys file, jwt secret file or jwt public key file is set")
+	}
+
+	if c.RateLimit.Enabled {
+		if err := c.RateLimit.Read.validate("read"); err != nil {
+			return err
+		}
+		if err := c.RateLimit.Write.validate("write"); err != nil {
+			return err
+		}
+		if err := c.RateLimit.AuthFailures.validate("auth_failures"); err != nil {
+			return err
+		}
+		for route, limit := range c.RateLimit.Routes {
+			if err := limit.validate(route); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l RateLimit) validate(name string) error {
+	if l.Rate <= 0 || l.Burst < 1 {
+		return fmt.Errorf("invalid rate limit %s: rate must be positive and burst at least 1", name)
+	}
 	return nil
 }
+
 func (c *DBconfig) GetDSN() string {
 	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
@@ -99,7 +316,22 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+// envParser reads typed environment variables and remembers every value that does not parse,
+// so a typo fails the start instead of silently keeping the default
+type envParser struct {
+	errs []error
+}
+
+func (p *envParser) invalid(key, value, want string) {
+	p.errs = append(p.errs, fmt.Errorf("invalid %s=%q: want %s", key, value, want))
+}
+
+// err joins the collected errors, nil if every variable parsed
+func (p *envParser) err() error {
+	return errors.Join(p.errs...)
+}
+
+func (p *envParser) getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	valueStr := os.Getenv(key)
 	if valueStr == "" {
 		return defaultValue
@@ -113,5 +345,48 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 		return duration
 	}
 
+	p.invalid(key, valueStr, "seconds or a duration like 90s")
+	return defaultValue
+}
+
+func (p *envParser) getBoolEnv(key string, defaultValue bool) bool {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	if value, err := strconv.ParseBool(valueStr); err == nil {
+		return value
+	}
+
+	p.invalid(key, valueStr, "true or false")
+	return defaultValue
+}
+
+func (p *envParser) getIntEnv(key string, defaultValue int) int {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	if value, err := strconv.Atoi(valueStr); err == nil {
+		return value
+	}
+
+	p.invalid(key, valueStr, "an integer")
+	return defaultValue
+}
+
+func (p *envParser) getFloatEnv(key string, defaultValue float64) float64 {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	if value, err := strconv.ParseFloat(valueStr, 64); err == nil {
+		return value
+	}
+
+	p.invalid(key, valueStr, "a number")
 	return defaultValue
 }
@@ -28,10 +28,16 @@ func ParsedDates(s model.Subscription) (model.SubscriptionDB, error) {
 	}, nil
 }
 
+const monthLayout = "01-2006"
+
 func ParseMMYYYY(s string) (time.Time, error) {
-	t, err := time.Parse("01-2006", s)
+	t, err := time.Parse(monthLayout, s)
 	if err != nil {
 		return time.Time{}, err
 	}
 	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), nil
 }
+
+func FormatMMYYYY(t time.Time) string {
+	return t.Format(monthLayout)
+}
@@ -3,41 +3,19 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"jobProject/internal/config"
 	"log"
-	"os"
 
 	_ "github.com/lib/pq"
 )
 
 var DB *sql.DB
 
-func InitDB() error {
-	host := os.Getenv("DB_HOST")
-	if host == "" {
-		host = "localhost"
-	}
-	user := os.Getenv("DB_USER")
-	if user == "" {
-		user = "postgres"
-	}
-	password := os.Getenv("DB_PASSWORD")
-	if password == "" {
-		password = "123456789"
-	}
-	dbname := os.Getenv("DB_NAME")
-	if dbname == "" {
-		dbname = "subs"
-	}
-	port := os.Getenv("DB_PORT")
-	if port == "" {
-		port = "5432"
-	}
-
-	connStr := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable options='-c application_name=subs-app'",
-		host, user, password, dbname, port)
+func InitDB(cfg config.DBconfig) error {
+	connStr := cfg.GetDSN() + " options='-c application_name=subs-app'"
 
 	var err error
-	log.Printf("connecting to host=%s dbname=%s port=%s user=%s sslmode=disable", host, dbname, port, user)
+	log.Printf("connecting to host=%s dbname=%s port=%s user=%s sslmode=%s", cfg.Host, cfg.DBName, cfg.Port, cfg.User, cfg.SSLMode)
 
 	DB, err = sql.Open("postgres", connStr)
 	if err != nil {
@@ -0,0 +1,279 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"jobProject/internal/db/migrations"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationLockID is the pg_advisory_lock key serializing migrations across replicas
+const migrationLockID = 7243591
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// LoadMigrations reads the embedded migrations ordered by version
+func LoadMigrations() ([]Migration, error) {
+	return loadMigrations(migrations.FS)
+}
+
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: want <version>_<name>.up.sql or .down.sql", file)
+		}
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: missing name", file)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", file, err)
+		}
+
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// withMigrationLock runs fn on a single connection holding the migration advisory lock,
+// so replicas starting at the same time apply migrations one after another
+func withMigrationLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+			log.Printf("error releasing migration lock: %v", err)
+		}
+	}()
+
+	const q = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`
+	if _, err := conn.ExecContext(ctx, q); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}) (map[int]time.Time, error) {
+	rows, err := q.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			version int
+			at      time.Time
+		)
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// runMigration executes one script and records the result in schema_migrations atomically
+func runMigration(ctx context.Context, conn *sql.Conn, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp applies every pending migration and returns how many were applied
+func MigrateUp(ctx context.Context, db *sql.DB) (int, error) {
+	list, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = withMigrationLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range list {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			err := runMigration(ctx, conn, m.Up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", m.Version, m.Name, err)
+			}
+			log.Printf("applied migration %d_%s", m.Version, m.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrateDown rolls back the latest steps applied migrations and returns how many were rolled back
+func MigrateDown(ctx context.Context, db *sql.DB, steps int) (int, error) {
+	list, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = withMigrationLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(list) - 1; i >= 0 && count < steps; i-- {
+			m := list[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+			}
+			err := runMigration(ctx, conn, m.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, err)
+			}
+			log.Printf("rolled back migration %d_%s", m.Version, m.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Migrations reports every known migration with the time it was applied, nil if pending
+func Migrations(ctx context.Context, db *sql.DB) ([]MigrationStatus, error) {
+	list, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied map[int]time.Time
+	err = withMigrationLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err = appliedVersions(ctx, conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(list))
+	for _, m := range list {
+		st := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			st.AppliedAt = &at
+		}
+		status = append(status, st)
+	}
+	return status, nil
+}
+
+// PendingMigrations counts embedded migrations not yet recorded in schema_migrations.
+// It takes no lock so it is cheap enough for readiness probes
+func PendingMigrations(ctx context.Context, db *sql.DB) (int, error) {
+	list, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return len(list), nil
+	}
+
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, m := range list {
+		if _, ok := applied[m.Version]; !ok {
+			pending++
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	list, err := LoadMigrations()
+	if err != nil {
+		t.Fatalf("embedded migrations: %v", err)
+	}
+	if len(list) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	for i, m := range list {
+		if m.Version != i+1 {
+			t.Errorf("migration %d_%s: want version %d, versions must be contiguous", m.Version, m.Name, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+		}
+	}
+}
+
+func TestLoadMigrationsParsing(t *testing.T) {
+	file := func(body string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(body)} }
+
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    []Migration
+		wantErr string
+	}{
+		{
+			name: "ordered by numeric version",
+			files: fstest.MapFS{
+				"10_late.up.sql":    file("late up"),
+				"2_early.up.sql":    file("early up"),
+				"2_early.down.sql":  file("early down"),
+				"0001_first.up.sql": file("first up"),
+			},
+			want: []Migration{
+				{Version: 1, Name: "first", Up: "first up"},
+				{Version: 2, Name: "early", Up: "early up", Down: "early down"},
+				{Version: 10, Name: "late", Up: "late up"},
+			},
+		},
+		{
+			name:  "name may contain underscores",
+			files: fstest.MapFS{"0003_subs_no_overlap.up.sql": file("up")},
+			want:  []Migration{{Version: 3, Name: "subs_no_overlap", Up: "up"}},
+		},
+		{
+			name:  "no files",
+			files: fstest.MapFS{},
+			want:  []Migration{},
+		},
+		{
+			name:    "unknown direction",
+			files:   fstest.MapFS{"0001_first.sideways.sql": file("x")},
+			wantErr: "want <version>_<name>.up.sql or .down.sql",
+		},
+		{
+			name:    "no direction",
+			files:   fstest.MapFS{"0001_first.sql": file("x")},
+			wantErr: "want <version>_<name>.up.sql or .down.sql",
+		},
+		{
+			name:    "missing name",
+			files:   fstest.MapFS{"0001.up.sql": file("x")},
+			wantErr: "missing name",
+		},
+		{
+			name:    "invalid version",
+			files:   fstest.MapFS{"v1_first.up.sql": file("x")},
+			wantErr: "invalid version",
+		},
+		{
+			name: "two names for one version",
+			files: fstest.MapFS{
+				"0001_first.up.sql":   file("x"),
+				"0001_other.down.sql": file("y"),
+			},
+			wantErr: "has two names",
+		},
+		{
+			name:    "down without up",
+			files:   fstest.MapFS{"0001_first.down.sql": file("x")},
+			wantErr: "has no up script",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadMigrations(tt.files)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("want error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d migrations, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("migration %d: got %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
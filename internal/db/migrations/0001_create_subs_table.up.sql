@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS subs_table (
+    id SERIAL PRIMARY KEY,
+    service VARCHAR(50) NOT NULL,
+    price INT DEFAULT 0,
+    user_id UUID NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE
+);
@@ -0,0 +1,2 @@
+ALTER TABLE subs_table DROP CONSTRAINT IF EXISTS subs_no_overlap;
+ALTER TABLE subs_table DROP COLUMN IF EXISTS allow_overlap;
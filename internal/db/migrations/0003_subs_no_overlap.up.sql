@@ -0,0 +1,23 @@
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+ALTER TABLE subs_table ADD COLUMN IF NOT EXISTS allow_overlap BOOLEAN NOT NULL DEFAULT false;
+
+-- rows stored before the check may already overlap: every row overlapping an earlier one
+-- is exempted as if stored with the "allow" policy, so the constraint below can be built
+UPDATE subs_table s SET allow_overlap = true
+WHERE NOT s.allow_overlap AND EXISTS (
+    SELECT 1 FROM subs_table earlier
+    WHERE earlier.user_id = s.user_id
+      AND earlier.service = s.service
+      AND NOT earlier.allow_overlap
+      AND (earlier.start_date, earlier.id) < (s.start_date, s.id)
+      AND daterange(earlier.start_date, earlier.end_date, '[]') && daterange(s.start_date, s.end_date, '[]')
+);
+
+-- rows stored with the "allow" overlap policy are exempt
+ALTER TABLE subs_table DROP CONSTRAINT IF EXISTS subs_no_overlap;
+ALTER TABLE subs_table ADD CONSTRAINT subs_no_overlap EXCLUDE USING gist (
+    user_id WITH =,
+    service WITH =,
+    daterange(start_date, end_date, '[]') WITH &&
+) WHERE (NOT allow_overlap);
@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS subs_table_deleted_at_idx;
+
+DELETE FROM subs_table WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE subs_table DROP CONSTRAINT IF EXISTS subs_no_overlap;
+ALTER TABLE subs_table ADD CONSTRAINT subs_no_overlap EXCLUDE USING gist (
+    user_id WITH =,
+    service WITH =,
+    daterange(start_date, end_date, '[]') WITH &&
+) WHERE (NOT allow_overlap);
+
+ALTER TABLE subs_table DROP COLUMN IF EXISTS deleted_at;
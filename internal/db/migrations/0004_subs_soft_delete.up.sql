@@ -0,0 +1,11 @@
+ALTER TABLE subs_table ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- soft-deleted rows neither block new subscriptions nor take part in the overlap check
+ALTER TABLE subs_table DROP CONSTRAINT IF EXISTS subs_no_overlap;
+ALTER TABLE subs_table ADD CONSTRAINT subs_no_overlap EXCLUDE USING gist (
+    user_id WITH =,
+    service WITH =,
+    daterange(start_date, end_date, '[]') WITH &&
+) WHERE (NOT allow_overlap AND deleted_at IS NULL);
+
+CREATE INDEX IF NOT EXISTS subs_table_deleted_at_idx ON subs_table (deleted_at) WHERE deleted_at IS NOT NULL;
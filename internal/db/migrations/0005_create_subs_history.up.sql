@@ -0,0 +1,13 @@
+-- append-only change log; no foreign key so the history outlives purged subscriptions
+CREATE TABLE IF NOT EXISTS subs_history (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id INT NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    old_values JSONB,
+    new_values JSONB,
+    actor TEXT NOT NULL,
+    request_id TEXT,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS subs_history_subscription_idx ON subs_history (subscription_id, changed_at);
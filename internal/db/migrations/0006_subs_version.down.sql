@@ -0,0 +1,2 @@
+ALTER TABLE subs_table DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE subs_table DROP COLUMN IF EXISTS version;
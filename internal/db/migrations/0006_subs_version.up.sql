@@ -0,0 +1,3 @@
+-- version is bumped by every change and exposed as the ETag for optimistic concurrency
+ALTER TABLE subs_table ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE subs_table ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1,2 @@
+ALTER TABLE subs_history DROP COLUMN IF EXISTS reason;
+ALTER TABLE subs_table DROP COLUMN IF EXISTS paused_from;
@@ -0,0 +1,4 @@
+-- paused_from is the first month a paused subscription is not billed, NULL while it runs
+ALTER TABLE subs_table ADD COLUMN IF NOT EXISTS paused_from DATE;
+-- reason is the free-form explanation given to cancel, pause, resume and renew
+ALTER TABLE subs_history ADD COLUMN IF NOT EXISTS reason TEXT;
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idempotency_keys_created_at_idx;
+
+-- the same key of several callers cannot stay unique, the oldest one is kept
+DELETE FROM idempotency_keys k USING idempotency_keys older
+WHERE k.key = older.key AND (k.created_at, k.scope) > (older.created_at, older.scope);
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS scope;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (key);
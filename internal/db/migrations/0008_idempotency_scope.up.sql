@@ -0,0 +1,9 @@
+-- keys are unique per caller, so one caller cannot probe or replay the keys of another;
+-- keys stored before the change belonged to no known caller
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT 'anonymous';
+ALTER TABLE idempotency_keys ALTER COLUMN scope DROP DEFAULT;
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (scope, key);
+
+-- expired keys are purged by age
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS subs_pauses;
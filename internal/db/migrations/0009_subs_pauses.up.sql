@@ -0,0 +1,21 @@
+-- finished pauses, so the months they covered stay unbilled after a resume; the pause still
+-- running is subs_table.paused_from. resumed_from is the first month billed again
+CREATE TABLE IF NOT EXISTS subs_pauses (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id INT NOT NULL REFERENCES subs_table (id) ON DELETE CASCADE,
+    paused_from DATE NOT NULL,
+    resumed_from DATE NOT NULL,
+    CHECK (resumed_from > paused_from)
+);
+
+CREATE INDEX IF NOT EXISTS subs_pauses_subscription_idx ON subs_pauses (subscription_id);
+
+-- pauses resumed before the table existed are recovered from the history: a resume
+-- reopened billing in the month it was made
+INSERT INTO subs_pauses (subscription_id, paused_from, resumed_from)
+SELECT h.subscription_id, to_date(h.old_values->>'paused_from', 'MM-YYYY'), date_trunc('month', h.changed_at AT TIME ZONE 'UTC')::date
+FROM subs_history h
+WHERE h.action = 'resume'
+  AND h.old_values ? 'paused_from'
+  AND date_trunc('month', h.changed_at AT TIME ZONE 'UTC')::date > to_date(h.old_values->>'paused_from', 'MM-YYYY')
+  AND EXISTS (SELECT 1 FROM subs_table s WHERE s.id = h.subscription_id);
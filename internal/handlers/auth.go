@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"jobProject/internal/auth"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// authenticator is nil when authentication is disabled
+var authenticator *auth.Authenticator
+
+func InitAuth(a *auth.Authenticator) {
+	authenticator = a
+}
+
+// RequireAuth rejects requests without valid credentials with 401 and stores the
+// caller in the request context, so the usecase can scope queries to its user_id.
+// Failed attempts are rate limited per client IP, so credentials cannot be guessed at will
+func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authenticator == nil {
+			next(w, r)
+			return
+		}
+
+		if authThrottled(w, r) {
+			return
+		}
+		principal, err := authenticator.Authenticate(r)
+		if err != nil {
+			countAuthFailure(r)
+			slog.WarnContext(r.Context(), "Authentication failed",
+				"error", err,
+				"path", r.URL.Path)
+			message := "invalid credentials"
+			if errors.Is(err, auth.ErrMissingCredentials) {
+				message = "missing credentials: send X-API-Key or Authorization: Bearer <token>"
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="subs-app"`)
+			writeError(w, r, http.StatusUnauthorized, message)
+			return
+		}
+
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			attribute.String("enduser.id", principal.Subject),
+			attribute.Bool("enduser.admin", principal.Admin),
+		)
+		slog.DebugContext(r.Context(), "Request authenticated",
+			"caller", principal.Name,
+			"method", principal.Method,
+			"admin", principal.Admin)
+		next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	}
+}
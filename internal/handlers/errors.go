@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"jobProject/internal/api"
+	"jobProject/internal/logger"
+	"jobProject/internal/usecase"
+	"log/slog"
+	"net/http"
+)
+
+const (
+	CodeBadRequest       = "bad_request"
+	CodeValidation       = "validation_error"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodePrecondition     = "precondition_failed"
+	CodeUnsupportedMedia = "unsupported_media_type"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal_error"
+)
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:           CodeBadRequest,
+	http.StatusUnauthorized:         CodeUnauthorized,
+	http.StatusForbidden:            CodeForbidden,
+	http.StatusNotFound:             CodeNotFound,
+	http.StatusMethodNotAllowed:     CodeMethodNotAllowed,
+	http.StatusConflict:             CodeConflict,
+	http.StatusPreconditionFailed:   CodePrecondition,
+	http.StatusUnsupportedMediaType: CodeUnsupportedMedia,
+	http.StatusTooManyRequests:      CodeRateLimited,
+	http.StatusInternalServerError:  CodeInternal,
+}
+
+func requestID(r *http.Request) string {
+	return logger.RequestID(r.Context())
+}
+
+// writeError responds with the JSON error envelope, the code is derived from the status
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string, details ...api.FieldError) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInternal
+	}
+	writeErrorCode(w, r, status, code, message, details...)
+}
+
+func writeErrorCode(w http.ResponseWriter, r *http.Request, status int, code, message string, details ...api.FieldError) {
+	response := api.ErrorResponse{
+		Error: api.ErrorBody{
+			Code:      code,
+			Message:   message,
+			Details:   details,
+			RequestID: requestID(r),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(r.Context(), "error encoding error response",
+			"error", err,
+			"status", status)
+	}
+}
+
+// writeValidationError responds 400 with the field errors collected from a usecase validation error
+func writeValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	var details []api.FieldError
+	for _, fe := range usecase.FieldErrors(err) {
+		details = append(details, api.FieldError{Field: fe.Field, Message: fe.Error()})
+	}
+	writeErrorCode(w, r, http.StatusBadRequest, CodeValidation, err.Error(), details...)
+}
+
+// discardRecorder keeps the status and headers a handler writes and drops its body
+type discardRecorder struct {
+	header http.Header
+	status int
+}
+
+func (d *discardRecorder) Header() http.Header         { return d.header }
+func (d *discardRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardRecorder) WriteHeader(status int)      { d.status = status }
+
+// JSONErrors answers the mux's own 404 and 405 with the JSON error envelope instead of plain text,
+// keeping the Allow header of a 405; matched routes and redirects are served as usual
+func JSONErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		rec := &discardRecorder{header: http.Header{}}
+		h.ServeHTTP(rec, r)
+		switch rec.status {
+		case http.StatusNotFound:
+			writeError(w, r, http.StatusNotFound, "route not found")
+		case http.StatusMethodNotAllowed:
+			if allow := rec.header.Get("Allow"); allow != "" {
+				w.Header().Set("Allow", allow)
+			}
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		default:
+			mux.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"jobProject/internal/api"
+	"jobProject/internal/db"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+
+	readinessTimeout = 2 * time.Second
+)
+
+// ready reports whether the instance should receive traffic; it is cleared
+// as soon as shutdown starts, before in-flight requests are drained
+var ready atomic.Bool
+
+func SetReady(v bool) { ready.Store(v) }
+func IsReady() bool   { return ready.Load() }
+
+var healthDB *sql.DB
+
+func InitHealth(database *sql.DB) error {
+	if database == nil {
+		return fmt.Errorf("nil database")
+	}
+	healthDB = database
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request, response api.HealthResponse) {
+	status := http.StatusOK
+	if response.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(r.Context(), "error encoding health response",
+			"error", err)
+	}
+}
+
+// @Summary Liveness
+// @Description Процесс жив и обслуживает HTTP
+// @Tags health
+// @Produce json
+// @Success 200 {object} api.HealthResponse
+// @Router /healthz [get]
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, r, api.HealthResponse{Status: StatusOK})
+}
+
+// @Summary Readiness
+// @Description Готовность принимать трафик: база отвечает, миграции применены, сервер не останавливается
+// @Tags health
+// @Produce json
+// @Success 200 {object} api.HealthResponse
+// @Failure 503 {object} api.HealthResponse
+// @Router /readyz [get]
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	response := api.HealthResponse{
+		Status:     StatusOK,
+		Components: map[string]api.ComponentStatus{},
+	}
+	set := func(name string, err error, pending *int) {
+		c := api.ComponentStatus{Status: StatusOK, Pending: pending}
+		if err != nil {
+			c.Status = StatusUnavailable
+			c.Error = err.Error()
+			response.Status = StatusUnavailable
+		}
+		response.Components[name] = c
+	}
+
+	if IsReady() {
+		set("server", nil, nil)
+	} else {
+		set("server", fmt.Errorf("shutting down"), nil)
+	}
+
+	dbErr := healthDB.PingContext(ctx)
+	set("database", dbErr, nil)
+
+	if dbErr == nil {
+		pending, err := db.PendingMigrations(ctx, healthDB)
+		if err == nil && pending > 0 {
+			err = fmt.Errorf("%d migration(s) pending", pending)
+		}
+		set("migrations", err, &pending)
+	} else {
+		set("migrations", fmt.Errorf("database unavailable"), nil)
+	}
+
+	if response.Status != StatusOK {
+		slog.WarnContext(r.Context(), "Instance is not ready",
+			"components", response.Components)
+	}
+	writeHealth(w, r, response)
+}
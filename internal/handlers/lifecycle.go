@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"jobProject/internal/api"
+	"jobProject/internal/model"
+	"jobProject/internal/usecase"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// lifecycleAction runs one of the lifecycle actions: it decodes the optional JSON body into
+// req, honours If-Match and answers with the changed subscription and its new ETag
+func lifecycleAction(w http.ResponseWriter, r *http.Request, action string, req any, run func(ctx context.Context, id int, pre model.Precondition) (model.SubscriptionDB, error)) {
+	idInt, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		slog.WarnContext(r.Context(), "conversation error",
+			"body", r.PathValue("id"),
+			"error", err)
+		writeError(w, r, http.StatusBadRequest, "conversation error")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+
+	defer r.Body.Close()
+
+	// every field is optional, so is the body
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		slog.WarnContext(r.Context(), "invalid json",
+			"action", action,
+			"error", err)
+		writeError(w, r, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+
+	sub, err := run(r.Context(), idInt, ifMatch(r))
+	if err != nil {
+		switch {
+		case usecase.IsValidationErr(err):
+			slog.WarnContext(r.Context(), "Validation error while changing subscription lifecycle",
+				"error", err,
+				"action", action,
+				"id", idInt)
+			writeValidationError(w, r, err)
+		case usecase.IsNotFoundErr(err):
+			slog.WarnContext(r.Context(), "Subscription not found while changing subscription lifecycle",
+				"action", action,
+				"id", idInt)
+			writeError(w, r, http.StatusNotFound, "subscription not found")
+		case usecase.IsConflictErr(err):
+			slog.WarnContext(r.Context(), "Conflict error while changing subscription lifecycle",
+				"error", err,
+				"action", action,
+				"id", idInt)
+			writeError(w, r, http.StatusConflict, err.Error())
+		case usecase.IsPreconditionErr(err):
+			slog.WarnContext(r.Context(), "Stale version while changing subscription lifecycle",
+				"action", action,
+				"id", idInt,
+				"if_match", r.Header.Get("If-Match"))
+			writeError(w, r, http.StatusPreconditionFailed, "subscription was modified, read it again and retry")
+		default:
+			slog.ErrorContext(r.Context(), "Internal error while changing subscription lifecycle",
+				"error", err,
+				"action", action,
+				"id", idInt)
+			writeError(w, r, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	slog.InfoContext(r.Context(), "subscription lifecycle changed",
+		"action", action,
+		"id", idInt,
+		"version", sub.Version)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(sub.Version))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(api.NewSubscriptionResponse(sub)); err != nil {
+		slog.ErrorContext(r.Context(), "error encoding response",
+			"error", err)
+	}
+}
+
+// @Summary Отменить подписку
+// @Description Завершает подписку указанным месяцем (по умолчанию текущим). Месяц не может быть раньше start_date, подписка, которая уже заканчивается к этому месяцу, дает конфликт
+// @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID подписки"
+// @Param If-Match header string false "ETag или список ETag, полученных при чтении подписки; слабые ETag не совпадают"
+// @Param request body api.CancelRequest false "Месяц окончания и причина"
+// @Success 200 {object} api.SubscriptionResponse
+// @Header 200 {string} ETag "Новая версия подписки"
+// @Failure 400 {object} api.ErrorResponse "Некорректный JSON или параметры"
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 404 {object} api.ErrorResponse "Подписка не найдена"
+// @Failure 409 {object} api.ErrorResponse "Подписка уже заканчивается к этому месяцу"
+// @Failure 412 {object} api.ErrorResponse "Подписка изменена после чтения, ETag не совпадает"
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse "Внутренняя ошибка"
+// @Router /api/v1/subscriptions/{id}/cancel [post]
+func CancelSubByID(w http.ResponseWriter, r *http.Request) {
+	var req api.CancelRequest
+	lifecycleAction(w, r, model.HistoryCancel, &req, func(ctx context.Context, id int, pre model.Precondition) (model.SubscriptionDB, error) {
+		return subUC.CancelSubscription(ctx, id, pre, req.EndDate, req.Reason)
+	})
+}
+
+// @Summary Приостановить подписку
+// @Description Перестает учитывать подписку в расходах начиная с указанного месяца (по умолчанию текущего) до возобновления
+// @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID подписки"
+// @Param If-Match header string false "ETag или список ETag, полученных при чтении подписки; слабые ETag не совпадают"
+// @Param request body api.PauseRequest false "Месяц начала паузы и причина"
+// @Success 200 {object} api.SubscriptionResponse
+// @Header 200 {string} ETag "Новая версия подписки"
+// @Failure 400 {object} api.ErrorResponse "Некорректный JSON или параметры"
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 404 {object} api.ErrorResponse "Подписка не найдена"
+// @Failure 409 {object} api.ErrorResponse "Подписка уже приостановлена или заканчивается раньше паузы"
+// @Failure 412 {object} api.ErrorResponse "Подписка изменена после чтения, ETag не совпадает"
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse "Внутренняя ошибка"
+// @Router /api/v1/subscriptions/{id}/pause [post]
+func PauseSubByID(w http.ResponseWriter, r *http.Request) {
+	var req api.PauseRequest
+	lifecycleAction(w, r, model.HistoryPause, &req, func(ctx context.Context, id int, pre model.Precondition) (model.SubscriptionDB, error) {
+		return subUC.PauseSubscription(ctx, id, pre, req.From, req.Reason)
+	})
+}
+
+// @Summary Возобновить подписку
+// @Description Снимает паузу с текущего месяца, подписка снова учитывается в расходах. Месяцы паузы в расходы по-прежнему не входят
+// @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID подписки"
+// @Param If-Match header string false "ETag или список ETag, полученных при чтении подписки; слабые ETag не совпадают"
+// @Param request body api.ResumeRequest false "Причина"
+// @Success 200 {object} api.SubscriptionResponse
+// @Header 200 {string} ETag "Новая версия подписки"
+// @Failure 400 {object} api.ErrorResponse "Некорректный JSON или параметры"
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 404 {object} api.ErrorResponse "Подписка не найдена"
+// @Failure 409 {object} api.ErrorResponse "Подписка не приостановлена"
+// @Failure 412 {object} api.ErrorResponse "Подписка изменена после чтения, ETag не совпадает"
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse "Внутренняя ошибка"
+// @Router /api/v1/subscriptions/{id}/resume [post]
+func ResumeSubByID(w http.ResponseWriter, r *http.Request) {
+	var req api.ResumeRequest
+	lifecycleAction(w, r, model.HistoryResume, &req, func(ctx context.Context, id int, pre model.Precondition) (model.SubscriptionDB, error) {
+		return subUC.ResumeSubscription(ctx, id, pre, req.Reason)
+	})
+}
+
+// @Summary Продлить подписку
+// @Description Сдвигает end_date на указанное число месяцев. Бессрочную подписку продлевать не нужно
+// @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID подписки"
+// @Param If-Match header string false "ETag или список ETag, полученных при чтении подписки; слабые ETag не совпадают"
+// @Param request body api.RenewRequest true "Число месяцев и причина"
+// @Success 200 {object} api.SubscriptionResponse
+// @Header 200 {string} ETag "Новая версия подписки"
+// @Failure 400 {object} api.ErrorResponse "Некорректный JSON или параметры"
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 404 {object} api.ErrorResponse "Подписка не найдена"
+// @Failure 409 {object} api.ErrorResponse "Подписка бессрочная или продление пересекается с другой подпиской"
+// @Failure 412 {object} api.ErrorResponse "Подписка изменена после чтения, ETag не совпадает"
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse "Внутренняя ошибка"
+// @Router /api/v1/subscriptions/{id}/renew [post]
+func RenewSubByID(w http.ResponseWriter, r *http.Request) {
+	var req api.RenewRequest
+	lifecycleAction(w, r, model.HistoryRenew, &req, func(ctx context.Context, id int, pre model.Precondition) (model.SubscriptionDB, error) {
+		return subUC.RenewSubscription(ctx, id, pre, req.Months, req.Reason)
+	})
+}
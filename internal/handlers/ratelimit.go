@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"jobProject/internal/auth"
+	"jobProject/internal/metrics"
+	"jobProject/internal/ratelimit"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// limiter is nil when rate limiting is disabled
+	limiter    *ratelimit.Limiter
+	trustProxy bool
+)
+
+func InitRateLimit(l *ratelimit.Limiter, trustForwardedFor bool) {
+	limiter = l
+	trustProxy = trustForwardedFor
+}
+
+// clientIP is the connection address, or the first X-Forwarded-For hop behind a trusted proxy
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientKey identifies the caller: the authenticated principal or the client IP. Unverified
+// credentials are ignored, they would let a client pick a fresh bucket per request
+func clientKey(r *http.Request) string {
+	if p, ok := auth.FromContext(r.Context()); ok {
+		return p.Method + ":" + p.Name
+	}
+	return "ip:" + clientIP(r)
+}
+
+// canAccessUser mirrors the usecase scope check: admins and callers without authentication
+// may access every user, anyone else only its own subject
+func canAccessUser(r *http.Request, userID string) bool {
+	owner := auth.Owner(r.Context())
+	return owner == "" || owner == userID
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, res ratelimit.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", ceilSeconds(res.Reset))
+}
+
+// rejectRateLimited answers 429 with the Retry-After of the exhausted bucket
+func rejectRateLimited(w http.ResponseWriter, r *http.Request, route string, res ratelimit.Result) {
+	metrics.ObserveRateLimited(route)
+	w.Header().Set("Retry-After", ceilSeconds(res.RetryAfter))
+	writeError(w, r, http.StatusTooManyRequests, "rate limit exceeded, retry after "+ceilSeconds(res.RetryAfter)+"s")
+}
+
+// authThrottled rejects a client IP that failed authentication too often, before its
+// credentials are checked again
+func authThrottled(w http.ResponseWriter, r *http.Request) bool {
+	if limiter == nil {
+		return false
+	}
+	res := limiter.AuthAllowed(clientIP(r))
+	if res.Allowed {
+		return false
+	}
+	slog.WarnContext(r.Context(), "Too many failed authentication attempts",
+		"client_ip", clientIP(r),
+		"retry_after", res.RetryAfter)
+	setRateLimitHeaders(w, res)
+	rejectRateLimited(w, r, routeOf(r), res)
+	return true
+}
+
+// countAuthFailure spends a token of the client IP for missing or invalid credentials
+func countAuthFailure(r *http.Request) {
+	if limiter != nil {
+		limiter.AuthFailed(clientIP(r))
+	}
+}
+
+// RateLimit counts the request against the bucket of the client and, when the
+// query names a user_id the caller may access, of that user. It must run inside RequireAuth so
+// authenticated callers are keyed by identity instead of IP
+func RateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limiter == nil {
+			next(w, r)
+			return
+		}
+
+		route := routeOf(r)
+		keys := []string{clientKey(r)}
+		// only a caller allowed to read the user's rows spends the user's bucket, so a
+		// forbidden request cannot drain it
+		if userID := r.URL.Query().Get("user_id"); validateUUID(userID) && canAccessUser(r, userID) {
+			keys = append(keys, "user:"+userID)
+		}
+
+		var tightest ratelimit.Result
+		for i, key := range keys {
+			res := limiter.Allow(route, r.Method, key)
+			if i == 0 || !res.Allowed || (tightest.Allowed && res.Remaining < tightest.Remaining) {
+				tightest = res
+			}
+			if !res.Allowed {
+				break
+			}
+		}
+		setRateLimitHeaders(w, tightest)
+
+		if !tightest.Allowed {
+			slog.WarnContext(r.Context(), "Rate limit exceeded",
+				"route", route,
+				"keys", keys,
+				"retry_after", tightest.RetryAfter)
+			rejectRateLimited(w, r, route, tightest)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -2,15 +2,20 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"jobProject/internal/api"
 	"jobProject/internal/conv"
 	"jobProject/internal/model"
+	"jobProject/internal/patch"
 	"jobProject/internal/usecase"
 	"log/slog"
+	"mime"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 
 	_ "jobProject/docs"
 )
@@ -23,6 +28,111 @@ func validateUUID(uuid string) bool {
 	return uuidRegex.MatchString(uuid)
 }
 
+// idFromRequest returns the {id} path segment, falling back to the ?id= query
+// parameter used by the deprecated RPC-style routes
+func idFromRequest(r *http.Request) string {
+	if id := r.PathValue("id"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("id")
+}
+
+// queryBool parses an optional boolean query flag, false when it is absent
+func queryBool(r *http.Request, name string) (bool, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(raw)
+}
+
+// includeDeleted parses the optional include_deleted query flag
+func includeDeleted(r *http.Request) (bool, error) {
+	return queryBool(r, "include_deleted")
+}
+
+// paginationParams parses the optional page and limit query parameters
+func paginationParams(r *http.Request) (api.PaginationParams, error) {
+	params := api.PaginationParams{Page: 1, Limit: 10}
+	if raw := r.URL.Query().Get("page"); raw != "" {
+		page, err := strconv.Atoi(raw)
+		if err != nil || page < 1 {
+			return params, fmt.Errorf("invalid page parameter: must be a positive integer")
+		}
+		params.Page = page
+	}
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > 100 {
+			return params, fmt.Errorf("invalid limit parameter: must be an integer from 1 to 100")
+		}
+		params.Limit = limit
+	}
+	return params, nil
+}
+
+// etag is the strong entity tag of a subscription version
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatch parses the If-Match header: "*" or a list of entity tags. Weak tags never pass
+// the strong comparison If-Match requires and malformed members match nothing, so both fail
+// the precondition with 412 instead of being rejected as a bad request
+func ifMatch(r *http.Request) model.Precondition {
+	values := r.Header.Values("If-Match")
+	if strings.TrimSpace(strings.Join(values, "")) == "" {
+		return model.Precondition{}
+	}
+	pre := model.Precondition{Present: true}
+	for _, value := range values {
+		for _, member := range strings.Split(value, ",") {
+			member = strings.TrimSpace(member)
+			if member == "*" {
+				pre.Any = true
+				continue
+			}
+			if len(member) < 2 || member[0] != '"' || member[len(member)-1] != '"' {
+				continue
+			}
+			if version, err := strconv.Atoi(member[1 : len(member)-1]); err == nil && version > 0 {
+				pre.Versions = append(pre.Versions, version)
+			}
+		}
+	}
+	return pre
+}
+
+// acceptPatch lists the patch formats of PATCH /subscriptions/{id}
+var acceptPatch = strings.Join([]string{patch.MediaTypeMergePatch, patch.MediaTypeJSONPatch, "application/json"}, ", ")
+
+var errUnsupportedPatch = errors.New("unsupported patch content type, want one of: " + acceptPatch)
+
+// decodePatch reads the body as a JSON Patch for application/json-patch+json and as a
+// JSON Merge Patch otherwise, plain application/json included
+func decodePatch(r *http.Request) (patch.Patch, error) {
+	mediaType := "application/json"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		parsed, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return nil, errUnsupportedPatch
+		}
+		mediaType = parsed
+	}
+	if mediaType != patch.MediaTypeJSONPatch && mediaType != patch.MediaTypeMergePatch && mediaType != "application/json" {
+		return nil, errUnsupportedPatch
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType == patch.MediaTypeJSONPatch {
+		return patch.ParseJSONPatch(body)
+	}
+	return patch.ParseMergePatch(body)
+}
+
 func Init(uc *usecase.SubUsecase) error {
 	if uc == nil {
 		return fmt.Errorf("nil usecase")
@@ -34,19 +144,27 @@ func Init(uc *usecase.SubUsecase) error {
 // @Summary Создать подписку
 // @Description Создает новую запись о подписке
 // @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Accept json
 // @Produce json
 // @Param subscription body model.Subscription true "Данные подписки"
-// @Success 201 {object} map[string]string
-// @Failure 400 {object} map[string]string "Некорректный JSON или параметры"
-// @Failure 409 {object} map[string]string "Конфликт"
-// @Router /CreateColumn [post]
+// @Param Idempotency-Key header string false "Ключ идемпотентности клиента: повтор с тем же ключом в течение срока хранения вернет исходный ответ"
+// @Success 201 {object} api.SubscriptionResponse
+// @Header 201 {string} Location "Адрес созданной подписки"
+// @Header 201 {string} ETag "Версия подписки"
+// @Failure 400 {object} api.ErrorResponse "Некорректный JSON или параметры"
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 409 {object} api.ErrorResponse "Пересечение с существующей подпиской или ключ идемпотентности использован с другим запросом"
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Router /api/v1/subscriptions [post]
 func CreateColumn(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		slog.Warn("Method not allowed",
+		slog.WarnContext(r.Context(), "Method not allowed",
 			"method", r.Method,
 			"path", r.URL.Path)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
@@ -56,149 +174,207 @@ func CreateColumn(w http.ResponseWriter, r *http.Request) {
 
 	var newSub model.Subscription
 
-	slog.Debug("Getting JSON with new column parameters",
+	slog.DebugContext(r.Context(), "Getting JSON with new column parameters",
 		"table", "subs_table")
 
 	err := json.NewDecoder(r.Body).Decode(&newSub)
 	if err != nil {
-		slog.Warn("invalid json",
+		slog.WarnContext(r.Context(), "invalid json",
 			"body", newSub,
 			"need", "service, price, user_id, start_date, end_date",
 			"error", err)
-
+		writeError(w, r, http.StatusBadRequest, "invalid json: "+err.Error())
 		return
 	}
 
-	if err := subUC.CreateColumnUC(r.Context(), newSub); err != nil {
+	var (
+		created  model.SubscriptionDB
+		replayed bool
+	)
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		created, replayed, err = subUC.CreateColumnIdempotentUC(r.Context(), key, newSub)
+	} else {
+		created, err = subUC.CreateColumnUC(r.Context(), newSub)
+	}
+	if err != nil {
 		switch {
 		case usecase.IsValidationErr(err):
-			slog.Warn("Validation error while subscription create",
+			slog.WarnContext(r.Context(), "Validation error while subscription create",
 				"error", err,
 				"service", newSub.Service,
 				"user_id", newSub.UserID)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeValidationError(w, r, err)
 		case usecase.IsConflictErr(err):
-			slog.Warn("Conflict error while subscription create",
+			slog.WarnContext(r.Context(), "Conflict error while subscription create",
 				"error", err,
 				"service", newSub.Service,
 				"user_id", newSub.UserID)
-			http.Error(w, err.Error(), http.StatusConflict)
+			writeError(w, r, http.StatusConflict, err.Error())
+		case usecase.IsForbiddenErr(err):
+			slog.WarnContext(r.Context(), "Forbidden while subscription create",
+				"error", err,
+				"service", newSub.Service,
+				"user_id", newSub.UserID)
+			writeError(w, r, http.StatusForbidden, err.Error())
 		default:
-			slog.Error("Internal error while subscription create",
+			slog.ErrorContext(r.Context(), "Internal error while subscription create",
 				"error", err,
 				"service", newSub.Service,
 				"user_id", newSub.UserID)
-			http.Error(w, "internal error", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "internal error")
 
 		}
 		return
 	}
 
-	slog.Info("Subscription created", "service", *newSub.Service)
+	slog.InfoContext(r.Context(), "Subscription created",
+		"id", created.ID,
+		"service", created.Service,
+		"replayed", replayed)
 
 	w.Header().Set("Content-Type", "application/json")
+	if replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/subscriptions/%d", created.ID))
+	// responses stored by idempotent creates before versioning carry no version
+	if created.Version > 0 {
+		w.Header().Set("ETag", etag(created.Version))
+	}
 	w.WriteHeader(http.StatusCreated)
-	response := map[string]string{"name of added subscription is": *newSub.Service}
-	err = json.NewEncoder(w).Encode(response)
+	err = json.NewEncoder(w).Encode(api.NewSubscriptionResponse(created))
 	if err != nil {
-		http.Error(w, "JSON encoding error: "+err.Error(), http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "error encoding response",
+			"error", err)
 	}
 }
 
 // @Summary Получить подписку по ID
 // @Description Возвращает подписку по идентификатору ID
 // @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Accept json
 // @Produce json
-// @Param id query int true "ID подписки"
-// @Success 200 {object} map[string]model.SubscriptionDB
-// @Failure 400 {object} map[string]string "Некорректный id или ошибка"
-// @Failure 404 {object} map[string]string "Подписка не найдена"
-// @Failure 409 {object} map[string]string "Конфликт"
-// @Failure 500 {object} map[string]string "Внутренняя ошибка"
-// @Router /ReadSubByID [get]
+// @Param id path int true "ID подписки"
+// @Param include_deleted query bool false "Вернуть и удаленную подписку (только для администратора)"
+// @Success 200 {object} api.SubscriptionResponse
+// @Header 200 {string} ETag "Версия подписки для If-Match"
+// @Failure 400 {object} api.ErrorResponse "Некорректный id или ошибка"
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 404 {object} api.ErrorResponse "Подписка не найдена"
+// @Failure 409 {object} api.ErrorResponse "Конфликт"
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse "Внутренняя ошибка"
+// @Router /api/v1/subscriptions/{id} [get]
 func ReadSubByID(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		slog.Warn("Method not allowed",
+		slog.WarnContext(r.Context(), "Method not allowed",
 			"method", r.Method,
 			"path", r.URL.Path)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	idStr := r.URL.Query().Get("id")
+	idStr := idFromRequest(r)
 	if idStr == "" {
-		slog.Warn("id input is clear",
-			"need", ".../DeleteColumnByID?id=1")
-		http.Error(w, "id input is clear", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "id input is clear",
+			"need", ".../api/v1/subscriptions/1")
+		writeError(w, r, http.StatusBadRequest, "id input is clear")
 		return
 	}
 
 	idInt, err := strconv.Atoi(idStr)
 	if err != nil {
-		slog.Error("conversation error",
+		slog.ErrorContext(r.Context(), "conversation error",
 			"body", idStr,
 			"error", err)
-		http.Error(w, "conversation error", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "conversation error")
+		return
+	}
+
+	withDeleted, err := includeDeleted(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "invalid include_deleted parameter",
+			"error", err)
+		writeError(w, r, http.StatusBadRequest, "invalid include_deleted parameter: must be a boolean")
 		return
 	}
 
-	sub, err := subUC.ReadColumnUC(r.Context(), idInt)
+	sub, err := subUC.ReadColumnUC(r.Context(), idInt, withDeleted)
 	if err != nil {
 		switch {
 		case usecase.IsValidationErr(err):
-			slog.Warn("Validation error while reading subscription",
+			slog.WarnContext(r.Context(), "Validation error while reading subscription",
 				"error", err,
 				"id", idInt)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeValidationError(w, r, err)
+		case usecase.IsNotFoundErr(err):
+			slog.WarnContext(r.Context(), "Subscription not found while reading subscription",
+				"id", idInt)
+			writeError(w, r, http.StatusNotFound, "subscription not found")
 		case usecase.IsConflictErr(err):
-			slog.Warn("Conflict error while reading subscription",
+			slog.WarnContext(r.Context(), "Conflict error while reading subscription",
 				"error", err,
 				"id", idInt)
-			http.Error(w, err.Error(), http.StatusConflict)
+			writeError(w, r, http.StatusConflict, err.Error())
+		case usecase.IsForbiddenErr(err):
+			slog.WarnContext(r.Context(), "Forbidden while reading subscription",
+				"error", err,
+				"id", idInt)
+			writeError(w, r, http.StatusForbidden, err.Error())
 		default:
-			slog.Error("Internal error while reading subscription",
+			slog.ErrorContext(r.Context(), "Internal error while reading subscription",
 				"error", err,
 				"id", idInt)
-			http.Error(w, "internal error", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "internal error")
 		}
 		return
 	}
 
-	slog.Info("you had read subscription",
+	slog.InfoContext(r.Context(), "you had read subscription",
 		"id", idInt)
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(sub.Version))
 	w.WriteHeader(http.StatusOK)
-	text := fmt.Sprintf("column id: %d", idInt)
-	response := map[string]model.SubscriptionDB{text: sub}
-	err = json.NewEncoder(w).Encode(response)
+	err = json.NewEncoder(w).Encode(api.NewSubscriptionResponse(sub))
 	if err != nil {
-		http.Error(w, "JSON encoding error: "+err.Error(), http.StatusInternalServerError)
-		return
+		slog.ErrorContext(r.Context(), "error encoding response",
+			"error", err)
 	}
 
 }
 
 // @Summary Частично обновить подписку по ID
-// @Description Обновляет выбранные поля записи
+// @Description Обновляет подписку атомарно. Тело application/json или application/merge-patch+json — JSON Merge Patch (RFC 7396): переданные поля заменяются, null очищает поле (например end_date). Тело application/json-patch+json — JSON Patch (RFC 6902), массив операций. Результат проверяется целиком вместе с сохраненными полями. Поля id, paused_from и deleted_at только для чтения: их можно прислать такими, как они были прочитаны, но не изменить. С заголовком If-Match патч применяется, только если подписка не менялась с чтения
 // @Tags subscriptions
-// @Accept json
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Accept json,application/merge-patch+json,application/json-patch+json
 // @Produce json
-// @Param id query int true "ID подписки"
+// @Param id path int true "ID подписки"
+// @Param If-Match header string false "ETag или список ETag, полученных при чтении подписки; слабые ETag не совпадают"
 // @Param subscription body model.Subscription true "Патч-данные"
-// @Success 200 {object} map[int]string "ID -> updated"
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string "Подписка не найдена"
-// @Failure 409 {object} map[string]string "Конфликт"
-// @Failure 500 {object} map[string]string
-// @Router /PatchColumnByID [patch]
+// @Success 200 {object} api.SubscriptionResponse "Обновленная подписка"
+// @Header 200 {string} ETag "Новая версия подписки"
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 404 {object} api.ErrorResponse "Подписка не найдена"
+// @Failure 409 {object} api.ErrorResponse "Пересечение с существующей подпиской, не выполнена операция test или подписка заканчивается раньше паузы"
+// @Failure 412 {object} api.ErrorResponse "Подписка изменена после чтения, ETag не совпадает"
+// @Failure 415 {object} api.ErrorResponse "Неподдерживаемый формат патча, см. Accept-Patch"
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse
+// @Router /api/v1/subscriptions/{id} [patch]
 func PatchColumnByID(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPatch {
-		slog.Warn("Method not allowed",
+		slog.WarnContext(r.Context(), "Method not allowed",
 			"method", r.Method,
 			"path", r.URL.Path)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
@@ -206,104 +382,250 @@ func PatchColumnByID(w http.ResponseWriter, r *http.Request) {
 
 	defer r.Body.Close()
 
-	var patchBody model.Subscription
-
-	err := json.NewDecoder(r.Body).Decode(&patchBody)
+	patchBody, err := decodePatch(r)
+	if errors.Is(err, errUnsupportedPatch) {
+		slog.WarnContext(r.Context(), "unsupported patch content type",
+			"content_type", r.Header.Get("Content-Type"))
+		w.Header().Set("Accept-Patch", acceptPatch)
+		writeError(w, r, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
 	if err != nil {
-		slog.Warn("invalid json",
-			"body", patchBody,
+		slog.WarnContext(r.Context(), "invalid patch",
+			"content_type", r.Header.Get("Content-Type"),
 			"need any of these", "service, price, user_id, start_date, end_date",
 			"error", err)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	idStr := r.URL.Query().Get("id")
+	idStr := idFromRequest(r)
 	if idStr == "" {
-		slog.Warn("id input is clear",
-			"need", ".../DeleteColumnByID?id=1")
-		http.Error(w, "id input is clear", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "id input is clear",
+			"need", ".../api/v1/subscriptions/1")
+		writeError(w, r, http.StatusBadRequest, "id input is clear")
 		return
 	}
 
 	idInt, err := strconv.Atoi(idStr)
 	if err != nil {
-		slog.Error("conversation error",
+		slog.ErrorContext(r.Context(), "conversation error",
 			"body", idStr,
 			"error", err)
-		http.Error(w, "conversation error", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "conversation error")
 		return
 	}
-	err = subUC.PatchColumnByID(r.Context(), idInt, patchBody)
+
+	pre := ifMatch(r)
+
+	updated, err := subUC.PatchColumnByID(r.Context(), idInt, pre, patchBody)
 	if err != nil {
 		switch {
 		case usecase.IsValidationErr(err):
-			slog.Warn("Validation error while patching subscription",
+			slog.WarnContext(r.Context(), "Validation error while patching subscription",
 				"error", err,
-				"patch body", patchBody)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+				"id", idInt)
+			writeValidationError(w, r, err)
+		case usecase.IsNotFoundErr(err):
+			slog.WarnContext(r.Context(), "Subscription not found while patching subscription",
+				"id", idInt)
+			writeError(w, r, http.StatusNotFound, "subscription not found")
 		case usecase.IsConflictErr(err):
-			slog.Warn("Conflict error while patching subscription",
+			slog.WarnContext(r.Context(), "Conflict error while patching subscription",
 				"error", err,
-				"patch body", patchBody)
-			http.Error(w, err.Error(), http.StatusConflict)
+				"id", idInt)
+			writeError(w, r, http.StatusConflict, err.Error())
+		case usecase.IsForbiddenErr(err):
+			slog.WarnContext(r.Context(), "Forbidden while patching subscription",
+				"error", err,
+				"id", idInt)
+			writeError(w, r, http.StatusForbidden, err.Error())
+		case usecase.IsPreconditionErr(err):
+			slog.WarnContext(r.Context(), "Stale version while patching subscription",
+				"id", idInt,
+				"if_match", r.Header.Get("If-Match"))
+			writeError(w, r, http.StatusPreconditionFailed, "subscription was modified, read it again and retry")
 		default:
-			slog.Error("Internal error while patching subscription",
+			slog.ErrorContext(r.Context(), "Internal error while patching subscription",
 				"error", err,
-				"patch body", patchBody)
-			http.Error(w, "internal error", http.StatusInternalServerError)
+				"id", idInt)
+			writeError(w, r, http.StatusInternalServerError, "internal error")
 		}
 		return
 	}
 
-	slog.Info("subscription patched",
-		"id", idInt)
+	slog.InfoContext(r.Context(), "subscription patched",
+		"id", idInt,
+		"version", updated.Version)
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(updated.Version))
 	w.WriteHeader(http.StatusOK)
-	response := map[int]string{idInt: "updated"}
-	err = json.NewEncoder(w).Encode(response)
+	err = json.NewEncoder(w).Encode(api.NewSubscriptionResponse(updated))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error encoding response",
+			"error", err)
+	}
+
+}
+
+// @Summary Заменить подписку по ID
+// @Description Заменяет все поля подписки, тело проверяется как при создании. С upsert=true отсутствующая подписка создается с этим ID, если он уже был выдан ранее, например у очищенной подписки того же пользователя; новые подписки создаются через POST. С заголовком If-Match замена выполняется, только если подписка не менялась с чтения
+// @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID подписки"
+// @Param upsert query bool false "Создать подписку, если ее нет; только для ранее выданных ID"
+// @Param If-Match header string false "ETag или список ETag, полученных при чтении подписки; слабые ETag не совпадают"
+// @Param subscription body model.Subscription true "Полные данные подписки"
+// @Success 200 {object} api.SubscriptionResponse "Подписка заменена"
+// @Header 200 {string} ETag "Новая версия подписки"
+// @Success 201 {object} api.SubscriptionResponse "Подписка создана"
+// @Header 201 {string} Location "Адрес созданной подписки"
+// @Header 201 {string} ETag "Версия подписки"
+// @Failure 400 {object} api.ErrorResponse "Некорректный JSON или параметры"
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 404 {object} api.ErrorResponse "Подписка не найдена"
+// @Failure 409 {object} api.ErrorResponse "Пересечение с существующей подпиской или подписка заканчивается раньше паузы"
+// @Failure 412 {object} api.ErrorResponse "Подписка изменена после чтения, ETag не совпадает"
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse "Внутренняя ошибка"
+// @Router /api/v1/subscriptions/{id} [put]
+func ReplaceSubByID(w http.ResponseWriter, r *http.Request) {
+	idInt, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		slog.WarnContext(r.Context(), "conversation error",
+			"body", r.PathValue("id"),
+			"error", err)
+		writeError(w, r, http.StatusBadRequest, "conversation error")
+		return
+	}
+
+	upsert, err := queryBool(r, "upsert")
+	if err != nil {
+		slog.WarnContext(r.Context(), "invalid upsert parameter",
+			"error", err)
+		writeError(w, r, http.StatusBadRequest, "invalid upsert parameter: must be a boolean")
+		return
+	}
+
+	pre := ifMatch(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+
+	defer r.Body.Close()
+
+	var sub model.Subscription
+
+	err = json.NewDecoder(r.Body).Decode(&sub)
 	if err != nil {
-		http.Error(w, "JSON encoding error: "+err.Error(), http.StatusInternalServerError)
+		slog.WarnContext(r.Context(), "invalid json",
+			"body", sub,
+			"need", "service, price, user_id, start_date, end_date",
+			"error", err)
+		writeError(w, r, http.StatusBadRequest, "invalid json: "+err.Error())
 		return
 	}
 
+	stored, created, err := subUC.ReplaceColumnByID(r.Context(), idInt, pre, sub, upsert)
+	if err != nil {
+		switch {
+		case usecase.IsValidationErr(err):
+			slog.WarnContext(r.Context(), "Validation error while replacing subscription",
+				"error", err,
+				"id", idInt)
+			writeValidationError(w, r, err)
+		case usecase.IsNotFoundErr(err):
+			slog.WarnContext(r.Context(), "Subscription not found while replacing subscription",
+				"id", idInt,
+				"upsert", upsert)
+			writeError(w, r, http.StatusNotFound, "subscription not found")
+		case usecase.IsConflictErr(err):
+			slog.WarnContext(r.Context(), "Conflict error while replacing subscription",
+				"error", err,
+				"id", idInt)
+			writeError(w, r, http.StatusConflict, err.Error())
+		case usecase.IsForbiddenErr(err):
+			slog.WarnContext(r.Context(), "Forbidden while replacing subscription",
+				"error", err,
+				"id", idInt)
+			writeError(w, r, http.StatusForbidden, err.Error())
+		case usecase.IsPreconditionErr(err):
+			slog.WarnContext(r.Context(), "Stale version while replacing subscription",
+				"id", idInt,
+				"if_match", r.Header.Get("If-Match"))
+			writeError(w, r, http.StatusPreconditionFailed, "subscription was modified, read it again and retry")
+		default:
+			slog.ErrorContext(r.Context(), "Internal error while replacing subscription",
+				"error", err,
+				"id", idInt)
+			writeError(w, r, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	slog.InfoContext(r.Context(), "subscription replaced",
+		"id", idInt,
+		"created", created,
+		"version", stored.Version)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(stored.Version))
+	status := http.StatusOK
+	if created {
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/subscriptions/%d", stored.ID))
+		status = http.StatusCreated
+	}
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(api.NewSubscriptionResponse(stored)); err != nil {
+		slog.ErrorContext(r.Context(), "error encoding response",
+			"error", err)
+	}
 }
 
 // @Summary Удалить подписку по ID
-// @Description Удаляет запись о подписке
+// @Description Помечает подписку удаленной, ее можно восстановить до окончательной очистки по сроку хранения
 // @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Accept json
 // @Produce json
-// @Param id query int true "ID подписки"
-// @Success 200 {object} map[string]string
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string "Подписка не найдена"
-// @Failure 409 {object} map[string]string
-// @Failure 500 {object} map[string]string
-// @Router /DeleteColumnByID [delete]
+// @Param id path int true "ID подписки"
+// @Success 204 "Подписка удалена"
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 404 {object} api.ErrorResponse "Подписка не найдена"
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse
+// @Router /api/v1/subscriptions/{id} [delete]
 func DeleteColumnByID(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		slog.Warn("Method not allowed",
+		slog.WarnContext(r.Context(), "Method not allowed",
 			"method", r.Method,
 			"path", r.URL.Path)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	idStr := r.URL.Query().Get("id")
+	idStr := idFromRequest(r)
 	if idStr == "" {
-		slog.Warn("id input is clear",
-			"need", ".../DeleteColumnByID?id=1")
-		http.Error(w, "id input is clear", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "id input is clear",
+			"need", ".../api/v1/subscriptions/1")
+		writeError(w, r, http.StatusBadRequest, "id input is clear")
 		return
 	}
 
 	idInt, err := strconv.Atoi(idStr)
 	if err != nil {
-		slog.Error("conversation error",
+		slog.ErrorContext(r.Context(), "conversation error",
 			"body", idStr,
 			"error", err)
-		http.Error(w, "conversation error", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "conversation error")
 		return
 	}
 
@@ -311,59 +633,202 @@ func DeleteColumnByID(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		switch {
 		case usecase.IsValidationErr(err):
-			slog.Warn("Validation error while subscription delete",
+			slog.WarnContext(r.Context(), "Validation error while subscription delete",
 				"error", err,
 				"id", idInt)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeValidationError(w, r, err)
+		case usecase.IsNotFoundErr(err):
+			slog.WarnContext(r.Context(), "Subscription not found while subscription delete",
+				"id", idInt)
+			writeError(w, r, http.StatusNotFound, "subscription not found")
 		case usecase.IsConflictErr(err):
-			slog.Warn("Conflict error while subscription delete",
+			slog.WarnContext(r.Context(), "Conflict error while subscription delete",
 				"error", err,
 				"id", idInt)
-			http.Error(w, err.Error(), http.StatusConflict)
+			writeError(w, r, http.StatusConflict, err.Error())
+		case usecase.IsForbiddenErr(err):
+			slog.WarnContext(r.Context(), "Forbidden while subscription delete",
+				"error", err,
+				"id", idInt)
+			writeError(w, r, http.StatusForbidden, err.Error())
 		default:
-			slog.Error("Internal error while subscription delete",
+			slog.ErrorContext(r.Context(), "Internal error while subscription delete",
 				"error", err,
 				"id", idInt)
-			http.Error(w, "internal error", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "internal error")
+
+		}
+		return
+	}
+
+	slog.InfoContext(r.Context(), "subscription deleted",
+		"id", idInt)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Восстановить удаленную подписку
+// @Description Снимает пометку об удалении, пока подписка не очищена по сроку хранения
+// @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "ID подписки"
+// @Success 200 {object} api.SubscriptionResponse
+// @Header 200 {string} ETag "Новая версия подписки"
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 404 {object} api.ErrorResponse "Удаленная подписка не найдена"
+// @Failure 409 {object} api.ErrorResponse "Пересечение с существующей подпиской"
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse
+// @Router /api/v1/subscriptions/{id}/restore [post]
+func RestoreSubByID(w http.ResponseWriter, r *http.Request) {
+	idInt, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		slog.WarnContext(r.Context(), "conversation error",
+			"body", r.PathValue("id"),
+			"error", err)
+		writeError(w, r, http.StatusBadRequest, "conversation error")
+		return
+	}
 
+	restored, err := subUC.RestoreColumnByID(r.Context(), idInt)
+	if err != nil {
+		switch {
+		case usecase.IsValidationErr(err):
+			slog.WarnContext(r.Context(), "Validation error while restoring subscription",
+				"error", err,
+				"id", idInt)
+			writeValidationError(w, r, err)
+		case usecase.IsNotFoundErr(err):
+			slog.WarnContext(r.Context(), "Deleted subscription not found while restoring",
+				"id", idInt)
+			writeError(w, r, http.StatusNotFound, "deleted subscription not found")
+		case usecase.IsConflictErr(err):
+			slog.WarnContext(r.Context(), "Conflict error while restoring subscription",
+				"error", err,
+				"id", idInt)
+			writeError(w, r, http.StatusConflict, err.Error())
+		default:
+			slog.ErrorContext(r.Context(), "Internal error while restoring subscription",
+				"error", err,
+				"id", idInt)
+			writeError(w, r, http.StatusInternalServerError, "internal error")
 		}
 		return
 	}
 
-	slog.Info("subscription deleted",
+	slog.InfoContext(r.Context(), "subscription restored",
 		"id", idInt)
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(restored.Version))
 	w.WriteHeader(http.StatusOK)
-	text := fmt.Sprintf("deleted column id: %d", idInt)
-	response := map[string]string{text: "OK"}
-	err = json.NewEncoder(w).Encode(response)
+	if err := json.NewEncoder(w).Encode(api.NewSubscriptionResponse(restored)); err != nil {
+		slog.ErrorContext(r.Context(), "error encoding response",
+			"error", err)
+	}
+}
+
+// @Summary История изменений подписки
+// @Description Возвращает записи журнала изменений подписки от старых к новым: действие, значения до и после, кто и в каком запросе изменил
+// @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "ID подписки"
+// @Param page query int false "Номер страницы"
+// @Param limit query int false "Размер страницы (до 100)"
+// @Success 200 {object} api.HistoryResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 404 {object} api.ErrorResponse "Подписка или ее история не найдены"
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse
+// @Router /api/v1/subscriptions/{id}/history [get]
+func SubscriptionHistory(w http.ResponseWriter, r *http.Request) {
+	idInt, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, "JSON encoding error: "+err.Error(), http.StatusInternalServerError)
+		slog.WarnContext(r.Context(), "conversation error",
+			"body", r.PathValue("id"),
+			"error", err)
+		writeError(w, r, http.StatusBadRequest, "conversation error")
 		return
 	}
+
+	params, err := paginationParams(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "invalid pagination parameters",
+			"error", err,
+			"id", idInt)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response, err := subUC.SubscriptionHistory(r.Context(), idInt, params)
+	if err != nil {
+		switch {
+		case usecase.IsValidationErr(err):
+			slog.WarnContext(r.Context(), "Validation error while reading history",
+				"error", err,
+				"id", idInt)
+			writeValidationError(w, r, err)
+		case usecase.IsNotFoundErr(err):
+			slog.WarnContext(r.Context(), "History not found",
+				"id", idInt)
+			writeError(w, r, http.StatusNotFound, "subscription history not found")
+		default:
+			slog.ErrorContext(r.Context(), "Internal error while reading history",
+				"error", err,
+				"id", idInt)
+			writeError(w, r, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Subscription history read",
+		"id", idInt,
+		"returned_count", len(response.Data),
+		"total", response.Pagination.Total)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(r.Context(), "error encoding response",
+			"error", err,
+			"id", idInt)
+	}
 }
 
 // @Summary Получить сумму подписок за период
-// @Description Считает суммарную стоимость подписок по id пользователя, названию подписки и периоду
+// @Description Считает суммарную стоимость подписок за период: цена умножается на число месяцев, в которые подписка активна внутри периода. Фильтры по пользователю и сервису необязательны, group_by добавляет разбивку
 // @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Accept json
 // @Produce json
-// @Param user_id query string true "ID пользователя (uuid)"
-// @Param service query string true "Название сервиса"
+// @Param user_id query string false "ID пользователя (uuid)"
+// @Param service query string false "Название сервиса"
 // @Param date_from query string true "Период начала подписки MM-YYYY"
 // @Param date_to query string true "Период конца подписки MM-YYYY"
-// @Success 200 {object} map[string]int
-// @Failure 400 {object} map[string]string
-// @Failure 409 {object} map[string]string
-// @Failure 500 {object} map[string]string
-// @Router /TotalPriceByPeriod [get]
+// @Param group_by query string false "Разбивка: service, user или month"
+// @Success 200 {object} api.TotalPriceResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse
+// @Router /api/v1/subscriptions/total [get]
 func TotalPriceByPeriod(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		slog.Warn("Method not allowed",
+		slog.WarnContext(r.Context(), "Method not allowed",
 			"method", r.Method,
 			"path", r.URL.Path)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
@@ -371,170 +836,272 @@ func TotalPriceByPeriod(w http.ResponseWriter, r *http.Request) {
 	service := r.URL.Query().Get("service")
 	dateFrom := r.URL.Query().Get("date_from")
 	dateTo := r.URL.Query().Get("date_to")
+	groupBy := r.URL.Query().Get("group_by")
 
-	if userID == "" || service == "" || dateFrom == "" || dateTo == "" {
-		slog.Warn("user_id, service, date_from, date_to required",
-			"body", fmt.Sprintf("required %v,%v, %v, %v", userID, service, dateFrom, dateTo))
-		http.Error(w, "user_id, service, date_from, date_to required", http.StatusBadRequest)
+	if dateFrom == "" || dateTo == "" {
+		slog.WarnContext(r.Context(), "date_from, date_to required",
+			"body", fmt.Sprintf("required %v, %v", dateFrom, dateTo))
+		writeError(w, r, http.StatusBadRequest, "date_from, date_to required")
 		return
 	}
 
-	if !validateUUID(userID) {
-		slog.Warn("invalid user_id format",
+	if userID != "" && !validateUUID(userID) {
+		slog.WarnContext(r.Context(), "invalid user_id format",
 			"user_id", userID,
 			"need", "must be a valid UUID (e.g., 70601fee-2bf1-4721-ae6f-7636e79a0cbb)")
-		http.Error(w, "invalid user_id format: must be a valid UUID (e.g., 70601fee-2bf1-4721-ae6f-7636e79a0cbb)", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid user_id format: must be a valid UUID (e.g., 70601fee-2bf1-4721-ae6f-7636e79a0cbb)")
 		return
 	}
 
 	fromTime, err := conv.ParseMMYYYY(dateFrom)
 	if err != nil {
-		slog.Warn("wrong date_from format",
+		slog.WarnContext(r.Context(), "wrong date_from format",
 			"fromTime", fromTime,
 			"need", "01-2006")
-		http.Error(w, "wrong date_from format", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "wrong date_from format")
 		return
 	}
 	toTime, err := conv.ParseMMYYYY(dateTo)
 	if err != nil {
-		slog.Warn("wrong date_from format",
+		slog.WarnContext(r.Context(), "wrong date_from format",
 			"toTime", toTime,
 			"need", "01-2006")
-		http.Error(w, "wrong date_to format", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "wrong date_to format")
 		return
 	}
 
-	total, err := subUC.TotalPriceByPeriod(r.Context(), userID, service, fromTime, toTime)
+	response, err := subUC.TotalPriceByPeriod(r.Context(), userID, service, groupBy, fromTime, toTime)
 	if err != nil {
-		if usecase.IsValidationErr(err) {
-			slog.Warn("Validation error",
+		switch {
+		case usecase.IsValidationErr(err):
+			slog.WarnContext(r.Context(), "Validation error",
 				"error", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		} else {
-			slog.Warn("Internal error",
+			writeValidationError(w, r, err)
+		case usecase.IsForbiddenErr(err):
+			slog.WarnContext(r.Context(), "Forbidden while counting total price",
+				"error", err,
+				"user_id", userID)
+			writeError(w, r, http.StatusForbidden, err.Error())
+		default:
+			slog.ErrorContext(r.Context(), "Internal error",
+				"error", err)
+			writeError(w, r, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Subscriptions had reveal",
+		"request body", fmt.Sprintf("required %v,%v, %v, %v", userID, service, dateFrom, dateTo),
+		"group_by", groupBy)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// @Summary Получить помесячные траты
+// @Description Возвращает по одной строке на каждый месяц периода: сумму активных подписок пользователя и их количество
+// @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param user_id query string true "ID пользователя (uuid)"
+// @Param date_from query string true "Первый месяц периода MM-YYYY"
+// @Param date_to query string true "Последний месяц периода MM-YYYY"
+// @Success 200 {array} model.MonthlySpend
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse
+// @Router /api/v1/subscriptions/monthly-spend [get]
+func MonthlySpend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		slog.WarnContext(r.Context(), "Method not allowed",
+			"method", r.Method,
+			"path", r.URL.Path)
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	dateFrom := r.URL.Query().Get("date_from")
+	dateTo := r.URL.Query().Get("date_to")
+
+	if userID == "" || dateFrom == "" || dateTo == "" {
+		slog.WarnContext(r.Context(), "user_id, date_from, date_to required",
+			"body", fmt.Sprintf("required %v, %v, %v", userID, dateFrom, dateTo))
+		writeError(w, r, http.StatusBadRequest, "user_id, date_from, date_to required")
+		return
+	}
+
+	if !validateUUID(userID) {
+		slog.WarnContext(r.Context(), "invalid user_id format",
+			"user_id", userID,
+			"need", "must be a valid UUID (e.g., 70601fee-2bf1-4721-ae6f-7636e79a0cbb)")
+		writeError(w, r, http.StatusBadRequest, "invalid user_id format: must be a valid UUID (e.g., 70601fee-2bf1-4721-ae6f-7636e79a0cbb)")
+		return
+	}
+
+	fromTime, err := conv.ParseMMYYYY(dateFrom)
+	if err != nil {
+		slog.WarnContext(r.Context(), "wrong date_from format",
+			"date_from", dateFrom,
+			"need", "01-2006")
+		writeError(w, r, http.StatusBadRequest, "wrong date_from format")
+		return
+	}
+	toTime, err := conv.ParseMMYYYY(dateTo)
+	if err != nil {
+		slog.WarnContext(r.Context(), "wrong date_to format",
+			"date_to", dateTo,
+			"need", "01-2006")
+		writeError(w, r, http.StatusBadRequest, "wrong date_to format")
+		return
+	}
+
+	series, err := subUC.MonthlySpend(r.Context(), userID, fromTime, toTime)
+	if err != nil {
+		switch {
+		case usecase.IsValidationErr(err):
+			slog.WarnContext(r.Context(), "Validation error",
 				"error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeValidationError(w, r, err)
+		case usecase.IsForbiddenErr(err):
+			slog.WarnContext(r.Context(), "Forbidden while building monthly spend",
+				"error", err,
+				"user_id", userID)
+			writeError(w, r, http.StatusForbidden, err.Error())
+		default:
+			slog.ErrorContext(r.Context(), "Internal error while building monthly spend",
+				"error", err,
+				"user_id", userID)
+			writeError(w, r, http.StatusInternalServerError, "internal error")
 		}
 		return
 	}
 
-	slog.Info("Subscriptions had reveal",
-		"request body", fmt.Sprintf("required %v,%v, %v, %v", userID, service, dateFrom, dateTo))
+	slog.InfoContext(r.Context(), "Monthly spend built",
+		"user_id", userID,
+		"months", len(series))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]int{"total": total})
+	if err := json.NewEncoder(w).Encode(series); err != nil {
+		slog.ErrorContext(r.Context(), "error encoding response",
+			"error", err,
+			"user_id", userID)
+	}
 }
 
+// @Summary Получить список подписок пользователя
+// @Description Возвращает подписки пользователя постранично
+// @Tags subscriptions
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param user_id query string true "ID пользователя (uuid)"
+// @Param page query int false "Номер страницы"
+// @Param limit query int false "Размер страницы (до 100)"
+// @Param include_deleted query bool false "Включить удаленные подписки (только для администратора)"
+// @Success 200 {object} api.PaginatedResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse "Нет или неверные учетные данные"
+// @Failure 403 {object} api.ErrorResponse "Доступ к подпискам другого пользователя"
+// @Failure 429 {object} api.ErrorResponse "Превышен лимит запросов, см. Retry-After"
+// @Failure 500 {object} api.ErrorResponse
+// @Router /api/v1/subscriptions [get]
 func ListSubscriptions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		slog.Warn("Method not allowed",
+		slog.WarnContext(r.Context(), "Method not allowed",
 			"method", r.Method,
 			"path", r.URL.Path,
 		)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	userID := r.URL.Query().Get("user_id")
 	if userID == "" {
-		slog.Warn("user_id is empty",
+		slog.WarnContext(r.Context(), "user_id is empty",
 			"path", r.URL.Path,
-			"need", ".../ListSubscriptions?user_id=70601fee-2bf1-4721-ae6f-7636e79a0cbb",
+			"need", ".../api/v1/subscriptions?user_id=70601fee-2bf1-4721-ae6f-7636e79a0cbb",
 		)
-		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "user_id parameter is required")
 		return
 	}
 
 	if !validateUUID(userID) {
-		slog.Warn("invalid user_id format",
+		slog.WarnContext(r.Context(), "invalid user_id format",
 			"user_id", userID,
 			"need", "must be a valid UUID (e.g., 70601fee-2bf1-4721-ae6f-7636e79a0cbb)",
 		)
-		http.Error(w, "invalid user_id format: must be a valid UUID (70601fee-2bf1-4721-ae6f-7636e79a0cbb)", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid user_id format: must be a valid UUID (70601fee-2bf1-4721-ae6f-7636e79a0cbb)")
 		return
 	}
 
-	pageStr := r.URL.Query().Get("page")
-	limitStr := r.URL.Query().Get("limit")
-
-	params := api.PaginationParams{
-		Page:  1,
-		Limit: 10,
-	}
-
-	if pageStr != "" {
-		page, err := strconv.Atoi(pageStr)
-		if err != nil || page < 1 {
-			slog.Warn("invalid page parameter",
-				"page_raw", pageStr,
-				"error", err,
-				"user_id", userID,
-			)
-			http.Error(w, "invalid page parameter: must be a positive integer", http.StatusBadRequest)
-			return
-		}
-		params.Page = page
+	params, err := paginationParams(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "invalid pagination parameters",
+			"error", err,
+			"user_id", userID,
+		)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	if limitStr != "" {
-		limit, err := strconv.Atoi(limitStr)
-		if err != nil || limit < 1 {
-			slog.Warn("invalid limit parameter",
-				"limit_raw", limitStr,
-				"error", err,
-				"user_id", userID,
-			)
-			http.Error(w, "invalid limit parameter: must be a positive integer", http.StatusBadRequest)
-			return
-		}
-		if limit > 100 {
-			slog.Warn("limit parameter too large",
-				"limit", limit,
-				"max_limit", 100,
-				"user_id", userID,
-			)
-			http.Error(w, "invalid limit parameter: maximum value is 100", http.StatusBadRequest)
-			return
-		}
-		params.Limit = limit
+	withDeleted, err := includeDeleted(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "invalid include_deleted parameter",
+			"error", err,
+			"user_id", userID,
+		)
+		writeError(w, r, http.StatusBadRequest, "invalid include_deleted parameter: must be a boolean")
+		return
 	}
 
-	params.Validate()
-
-	slog.Debug("Listing subscriptions",
+	slog.DebugContext(r.Context(), "Listing subscriptions",
 		"user_id", userID,
 		"page", params.Page,
 		"limit", params.Limit,
 		"offset", params.GetOffset(),
 	)
 
-	response, err := subUC.ListSubscriptions(r.Context(), userID, params)
+	response, err := subUC.ListSubscriptions(r.Context(), userID, params, withDeleted)
+	if usecase.IsForbiddenErr(err) {
+		slog.WarnContext(r.Context(), "Forbidden while listing subscriptions",
+			"error", err,
+			"user_id", userID)
+		writeError(w, r, http.StatusForbidden, err.Error())
+		return
+	}
 	if err != nil {
-		slog.Error("error listing subscriptions",
+		slog.ErrorContext(r.Context(), "error listing subscriptions",
 			"error", err,
 			"user_id", userID,
 			"page", params.Page,
 			"limit", params.Limit,
 		)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "internal error")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		slog.Error("error encoding response",
+		slog.ErrorContext(r.Context(), "error encoding response",
 			"error", err,
 			"user_id", userID,
 			"page", params.Page,
 			"limit", params.Limit,
 		)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "internal error")
 		return
 	}
 
-	slog.Info("Subscriptions listed successfully",
+	slog.InfoContext(r.Context(), "Subscriptions listed successfully",
 		"user_id", userID,
 		"page", params.Page,
 		"limit", params.Limit,
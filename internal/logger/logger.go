@@ -3,12 +3,13 @@ package logger
 import (
 	"log/slog"
 	"os"
+	"strings"
 )
 
 func InitLogger(level string) {
 	var logLevel slog.Level
 
-	switch level {
+	switch strings.ToUpper(level) {
 	case "DEBUG":
 		logLevel = slog.LevelDebug
 
@@ -28,7 +29,7 @@ func InitLogger(level string) {
 	}
 
 	handler := slog.NewJSONHandler(os.Stdout, opts)
-	logger := slog.New(handler)
+	logger := slog.New(&contextHandler{Handler: handler})
 
 	slog.SetDefault(logger)
 }
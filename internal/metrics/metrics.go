@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"jobProject/internal/model"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "subs"
+
+// scrapeTimeout bounds the database queries behind the business gauges
+const scrapeTimeout = 3 * time.Second
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected with 429 by route.",
+	}, []string{"route"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, rateLimited)
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// knownMethods are the request methods kept as label values, any other is counted as OTHER
+// so clients cannot create series with made-up methods
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+func methodLabel(method string) string {
+	if knownMethods[method] {
+		return method
+	}
+	return "OTHER"
+}
+
+// ObserveHTTP records one served request; route is the mux pattern it matched
+func ObserveHTTP(route, method string, status int, elapsed time.Duration) {
+	method = methodLabel(method)
+	httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(route, method).Observe(elapsed.Seconds())
+}
+
+func ObserveRateLimited(route string) {
+	rateLimited.WithLabelValues(route).Inc()
+}
+
+// RegisterDB exports the sql.DBStats connection pool gauges
+func RegisterDB(db *sql.DB) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(db, namespace))
+}
+
+type StatsSource interface {
+	ActiveStats(ctx context.Context) (model.ActiveStats, error)
+}
+
+// businessCollector queries the source on every scrape so the gauges are never stale
+type businessCollector struct {
+	source StatsSource
+	active *prometheus.Desc
+	mrr    *prometheus.Desc
+}
+
+func (c *businessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.active
+	ch <- c.mrr
+}
+
+func (c *businessCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+
+	stats, err := c.source.ActiveStats(ctx)
+	if err != nil {
+		slog.Warn("failed to collect business metrics",
+			"error", err)
+		ch <- prometheus.NewInvalidMetric(c.active, err)
+		ch <- prometheus.NewInvalidMetric(c.mrr, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(stats.Count))
+	ch <- prometheus.MustNewConstMetric(c.mrr, prometheus.GaugeValue, float64(stats.MRR))
+}
+
+// RegisterBusiness exports the active subscriptions count and monthly recurring revenue
+func RegisterBusiness(source StatsSource) error {
+	return prometheus.Register(&businessCollector{
+		source: source,
+		active: prometheus.NewDesc(namespace+"_active_subscriptions", "Subscriptions active in the current month.", nil, nil),
+		mrr:    prometheus.NewDesc(namespace+"_monthly_recurring_revenue", "Sum of prices of subscriptions active in the current month.", nil, nil),
+	})
+}
@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 type Subscription struct {
 	ID        int     `json:"id,omitempty"`
@@ -18,4 +21,99 @@ type SubscriptionDB struct {
 	UserID    string
 	StartDate time.Time
 	EndDate   *time.Time
+	DeletedAt *time.Time
+	// PausedFrom is the first month a paused subscription is not billed, nil while it runs
+	PausedFrom *time.Time
+	// AllowOverlap exempts the row from the overlap check, see OverlapAllow
+	AllowOverlap bool
+	// Version starts at 1 and is bumped by every change, it backs the ETag
+	Version   int
+	UpdatedAt time.Time
+}
+
+// Precondition is the If-Match of a change. The zero value, an absent header, matches any
+// version; Any is "*", which matches any existing subscription
+type Precondition struct {
+	Present bool
+	Any     bool
+	// Versions are the strong ETags listed in the header, weak ones never match
+	Versions []int
+}
+
+// Matches reports whether a subscription at version may be changed
+func (p Precondition) Matches(version int) bool {
+	if !p.Present || p.Any {
+		return true
+	}
+	return slices.Contains(p.Versions, version)
+}
+
+const (
+	GroupByService = "service"
+	GroupByUser    = "user"
+	GroupByMonth   = "month"
+)
+
+type PriceBreakdown struct {
+	Key   string `json:"key"`
+	Total int    `json:"total"`
+}
+
+type MonthlySpend struct {
+	Month       string `json:"month"`
+	Total       int    `json:"total"`
+	ActiveCount int    `json:"active_count"`
+}
+
+// OverlapPolicy decides what happens when a subscription overlaps another one
+// of the same user and service
+type OverlapPolicy string
+
+const (
+	OverlapReject OverlapPolicy = "reject"
+	OverlapAllow  OverlapPolicy = "allow"
+	OverlapMerge  OverlapPolicy = "merge"
+)
+
+type ActiveStats struct {
+	Count int
+	MRR   int
+}
+
+const (
+	HistoryCreate  = "create"
+	HistoryUpdate  = "update"
+	HistoryDelete  = "delete"
+	HistoryRestore = "restore"
+	HistoryMerge   = "merge"
+	HistoryPurge   = "purge"
+	HistoryCancel  = "cancel"
+	HistoryPause   = "pause"
+	HistoryResume  = "resume"
+	HistoryRenew   = "renew"
+)
+
+// SubscriptionState is a subscription as recorded in its change history
+type SubscriptionState struct {
+	Service    string  `json:"service"`
+	Price      int     `json:"price"`
+	UserID     string  `json:"user_id"`
+	StartDate  string  `json:"start_date"`
+	EndDate    *string `json:"end_date,omitempty"`
+	PausedFrom *string `json:"paused_from,omitempty"`
+}
+
+// HistoryEntry is one change of a subscription; OldValues is nil for a create,
+// NewValues is nil for a delete, merge or purge
+type HistoryEntry struct {
+	ID             int64
+	SubscriptionID int
+	Action         string
+	OldValues      *SubscriptionState
+	NewValues      *SubscriptionState
+	Actor          string
+	RequestID      string
+	// Reason is given by the caller of a lifecycle action
+	Reason    string
+	ChangedAt time.Time
 }
@@ -0,0 +1,271 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902) documents.
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	MediaTypeMergePatch = "application/merge-patch+json"
+	MediaTypeJSONPatch  = "application/json-patch+json"
+)
+
+var (
+	ErrInvalid = errors.New("invalid patch")
+	// ErrTestFailed means a JSON Patch test operation did not match the document
+	ErrTestFailed = errors.New("patch test failed")
+)
+
+// Patch transforms a JSON document
+type Patch interface {
+	Apply(doc []byte) ([]byte, error)
+	// Empty reports whether the patch cannot change any document
+	Empty() bool
+}
+
+// MergePatch is an RFC 7396 patch: object members replace the target members,
+// null members remove them and any other value replaces the whole target
+type MergePatch struct {
+	patch any
+}
+
+func ParseMergePatch(data []byte) (MergePatch, error) {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return MergePatch{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	return MergePatch{patch: v}, nil
+}
+
+func (m MergePatch) Empty() bool {
+	obj, ok := m.patch.(map[string]any)
+	return ok && len(obj) == 0
+}
+
+func (m MergePatch) Apply(doc []byte) ([]byte, error) {
+	var target any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merge(target, m.patch))
+}
+
+// merge is the MergePatch function of RFC 7396 section 2
+func merge(target, patch any) any {
+	obj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for name, value := range obj {
+		if value == nil {
+			delete(t, name)
+			continue
+		}
+		t[name] = merge(t[name], value)
+	}
+	return t
+}
+
+// Operation is one step of an RFC 6902 patch. Value is kept raw so an explicit
+// null can be told apart from a missing value
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch is an RFC 6902 patch, its operations are applied in order and
+// the document is left untouched if any of them fails
+type JSONPatch []Operation
+
+func ParseJSONPatch(data []byte) (JSONPatch, error) {
+	var ops JSONPatch
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	for i, op := range ops {
+		if err := op.validate(); err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %w", ErrInvalid, i, err)
+		}
+	}
+	return ops, nil
+}
+
+func (op Operation) validate() error {
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return fmt.Errorf("%s requires a value", op.Op)
+		}
+	case "move", "copy":
+		if _, err := parsePointer(op.From); err != nil {
+			return err
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("unknown op %q", op.Op)
+	}
+	_, err := parsePointer(op.Path)
+	return err
+}
+
+func (p JSONPatch) Empty() bool { return len(p) == 0 }
+
+func (p JSONPatch) Apply(doc []byte) ([]byte, error) {
+	var target any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	for i, op := range p {
+		var err error
+		target, err = op.apply(target)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+func (op Operation) apply(doc any) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	if op.Value != nil {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return add(doc, path, value)
+	case "remove":
+		doc, _, err := remove(doc, path)
+		return doc, err
+	case "replace":
+		if _, err := get(doc, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		return update(doc, path, func(container any, key string) (any, error) {
+			return set(container, key, value)
+		})
+	case "test":
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(current, value) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	case "move":
+		from, _ := parsePointer(op.From)
+		if isProperPrefix(from, path) {
+			return nil, errors.New("cannot move a value into itself")
+		}
+		doc, moved, err := remove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, moved)
+	case "copy":
+		from, _ := parsePointer(op.From)
+		copied, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, deepCopy(copied))
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(doc, path, func(container any, key string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			c[key] = value
+			return c, nil
+		case []any:
+			if key == "-" {
+				return append(c, value), nil
+			}
+			i, err := index(key, len(c)+1)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		}
+		return nil, errNotContainer
+	})
+}
+
+func remove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("cannot remove the whole document")
+	}
+	var removed any
+	doc, err := update(doc, path, func(container any, key string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			v, ok := c[key]
+			if !ok {
+				return nil, errNotFound
+			}
+			removed = v
+			delete(c, key)
+			return c, nil
+		case []any:
+			i, err := index(key, len(c))
+			if err != nil {
+				return nil, err
+			}
+			removed = c[i]
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, errNotContainer
+	})
+	return doc, removed, err
+}
+
+func equal(a, b any) bool {
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(x) == string(y)
+}
+
+func deepCopy(v any) any {
+	data, _ := json.Marshal(v)
+	var c any
+	_ = json.Unmarshal(data, &c)
+	return c
+}
+
+func isProperPrefix(prefix, path []string) bool {
+	if len(prefix) >= len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,450 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func jsonEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("result is not JSON: %s", got)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("bad expectation %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// The examples of RFC 7396 appendix A
+func TestMergePatchRFC7396(t *testing.T) {
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target+"+"+tt.patch, func(t *testing.T) {
+			p, err := ParseMergePatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			got, err := p.Apply([]byte(tt.target))
+			if err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			jsonEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestMergePatchParse(t *testing.T) {
+	if _, err := ParseMergePatch([]byte(`{"a":`)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("malformed JSON: want ErrInvalid, got %v", err)
+	}
+
+	tests := []struct {
+		patch string
+		empty bool
+	}{
+		{`{}`, true},
+		{`{"a":null}`, false},
+		{`[]`, false},
+		{`null`, false},
+	}
+	for _, tt := range tests {
+		p, err := ParseMergePatch([]byte(tt.patch))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.patch, err)
+		}
+		if p.Empty() != tt.empty {
+			t.Errorf("%s: Empty() = %v, want %v", tt.patch, p.Empty(), tt.empty)
+		}
+	}
+}
+
+type jsonPatchCase struct {
+	name  string
+	doc   string
+	patch string
+	// want is the patched document; empty when applying must fail
+	want string
+	// testFailed expects ErrTestFailed when applying fails
+	testFailed bool
+}
+
+func runJSONPatch(t *testing.T, tests []jsonPatchCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseJSONPatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			got, err := p.Apply([]byte(tt.doc))
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("want an error, got %s", got)
+				}
+				if tt.testFailed != errors.Is(err, ErrTestFailed) {
+					t.Errorf("ErrTestFailed = %v, want %v: %v", errors.Is(err, ErrTestFailed), tt.testFailed, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			jsonEqual(t, got, tt.want)
+		})
+	}
+}
+
+// The examples of RFC 6902 appendix A. A.13, a duplicated "op" member, is not detectable
+// with encoding/json, which keeps the last member
+func TestJSONPatchRFC6902(t *testing.T) {
+	runJSONPatch(t, []jsonPatchCase{
+		{
+			name:  "A.1 adding an object member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want:  `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			name:  "A.2 adding an array element",
+			doc:   `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want:  `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			name:  "A.3 removing an object member",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"}]`,
+			want:  `{"foo":"bar"}`,
+		},
+		{
+			name:  "A.4 removing an array element",
+			doc:   `{"foo":["bar","qux","baz"]}`,
+			patch: `[{"op":"remove","path":"/foo/1"}]`,
+			want:  `{"foo":["bar","baz"]}`,
+		},
+		{
+			name:  "A.5 replacing a value",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			want:  `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			name:  "A.6 moving a value",
+			doc:   `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			want:  `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			name:  "A.7 moving an array element",
+			doc:   `{"foo":["all","grass","cows","eat"]}`,
+			patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			want:  `{"foo":["all","cows","eat","grass"]}`,
+		},
+		{
+			name:  "A.8 testing a value: success",
+			doc:   `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			want:  `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			name:       "A.9 testing a value: error",
+			doc:        `{"baz":"qux"}`,
+			patch:      `[{"op":"test","path":"/baz","value":"bar"}]`,
+			testFailed: true,
+		},
+		{
+			name:  "A.10 adding a nested member object",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			want:  `{"foo":"bar","child":{"grandchild":{}}}`,
+		},
+		{
+			name:  "A.11 ignoring unrecognized elements",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			want:  `{"foo":"bar","baz":"qux"}`,
+		},
+		{
+			name:  "A.12 adding to a nonexistent target",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+		},
+		{
+			name:  "A.14 ~ escape ordering",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":10}]`,
+			want:  `{"/":9,"~1":10}`,
+		},
+		{
+			name:       "A.15 comparing strings and numbers",
+			doc:        `{"/":9,"~1":10}`,
+			patch:      `[{"op":"test","path":"/~01","value":"10"}]`,
+			testFailed: true,
+		},
+		{
+			name:  "A.16 adding an array value",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			want:  `{"foo":["bar",["abc","def"]]}`,
+		},
+	})
+}
+
+func TestJSONPatchEscaping(t *testing.T) {
+	runJSONPatch(t, []jsonPatchCase{
+		{
+			name:  "~1 is a slash",
+			doc:   `{"a/b":1}`,
+			patch: `[{"op":"replace","path":"/a~1b","value":2}]`,
+			want:  `{"a/b":2}`,
+		},
+		{
+			name:  "~0 is a tilde",
+			doc:   `{"m~n":1}`,
+			patch: `[{"op":"remove","path":"/m~0n"}]`,
+			want:  `{}`,
+		},
+		{
+			name:  "empty key",
+			doc:   `{"":1}`,
+			patch: `[{"op":"replace","path":"/","value":2}]`,
+			want:  `{"":2}`,
+		},
+		{
+			name:  "slash in a key is not a separator",
+			doc:   `{"a":{"b":1},"a/b":2}`,
+			patch: `[{"op":"remove","path":"/a~1b"}]`,
+			want:  `{"a":{"b":1}}`,
+		},
+		{
+			name:  "empty path is the whole document",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"replace","path":"","value":["x"]}]`,
+			want:  `["x"]`,
+		},
+	})
+}
+
+func TestJSONPatchArrayIndexes(t *testing.T) {
+	runJSONPatch(t, []jsonPatchCase{
+		{
+			name:  "add at the length appends",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"add","path":"/a/2","value":3}]`,
+			want:  `{"a":[1,2,3]}`,
+		},
+		{
+			name:  "add at index 0 prepends",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"add","path":"/a/0","value":0}]`,
+			want:  `{"a":[0,1,2]}`,
+		},
+		{
+			name:  "add past the length",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"add","path":"/a/3","value":3}]`,
+		},
+		{
+			name:  "leading zero",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"replace","path":"/a/01","value":3}]`,
+		},
+		{
+			name:  "negative index",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"remove","path":"/a/-1"}]`,
+		},
+		{
+			name:  "dash only appends",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"remove","path":"/a/-"}]`,
+		},
+		{
+			name:  "replace at the length",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"replace","path":"/a/2","value":3}]`,
+		},
+		{
+			name:  "remove at the length",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"remove","path":"/a/2"}]`,
+		},
+		{
+			name:  "test at the length",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"test","path":"/a/2","value":null}]`,
+		},
+		{
+			name:  "not a number",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"replace","path":"/a/x","value":3}]`,
+		},
+		{
+			name:  "index into a scalar",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"add","path":"/a/0","value":3}]`,
+		},
+		{
+			name:  "nested arrays are stored back",
+			doc:   `{"a":[[1],[2]]}`,
+			patch: `[{"op":"add","path":"/a/1/-","value":3}]`,
+			want:  `{"a":[[1],[2,3]]}`,
+		},
+	})
+}
+
+func TestJSONPatchMoveAndCopy(t *testing.T) {
+	runJSONPatch(t, []jsonPatchCase{
+		{
+			name:  "move into its own child",
+			doc:   `{"a":{"b":{}}}`,
+			patch: `[{"op":"move","from":"/a","path":"/a/b/c"}]`,
+		},
+		{
+			name:  "move onto itself",
+			doc:   `{"a":{"b":1}}`,
+			patch: `[{"op":"move","from":"/a","path":"/a"}]`,
+			want:  `{"a":{"b":1}}`,
+		},
+		{
+			name:  "move to a sibling sharing a prefix",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"move","from":"/a","path":"/ab"}]`,
+			want:  `{"ab":1}`,
+		},
+		{
+			name:  "move a missing value",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"move","from":"/b","path":"/c"}]`,
+		},
+		{
+			name:  "copy is deep",
+			doc:   `{"a":{"b":1}}`,
+			patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`,
+			want:  `{"a":{"b":1},"c":{"b":2}}`,
+		},
+		{
+			name:  "replace a missing member",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"replace","path":"/b","value":2}]`,
+		},
+		{
+			name:  "remove the whole document",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"remove","path":""}]`,
+		},
+	})
+}
+
+func TestJSONPatchTestEquality(t *testing.T) {
+	runJSONPatch(t, []jsonPatchCase{
+		{
+			name:  "object member order does not matter",
+			doc:   `{"a":{"x":1,"y":[1,2]}}`,
+			patch: `[{"op":"test","path":"/a","value":{"y":[1,2],"x":1}}]`,
+			want:  `{"a":{"x":1,"y":[1,2]}}`,
+		},
+		{
+			name:  "numbers compare by value",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"test","path":"/a","value":1.0}]`,
+			want:  `{"a":1}`,
+		},
+		{
+			name:  "explicit null",
+			doc:   `{"a":null}`,
+			patch: `[{"op":"test","path":"/a","value":null}]`,
+			want:  `{"a":null}`,
+		},
+		{
+			name:       "array order matters",
+			doc:        `{"a":[1,2]}`,
+			patch:      `[{"op":"test","path":"/a","value":[2,1]}]`,
+			testFailed: true,
+		},
+		{
+			name:       "extra member",
+			doc:        `{"a":{"x":1}}`,
+			patch:      `[{"op":"test","path":"/a","value":{"x":1,"y":2}}]`,
+			testFailed: true,
+		},
+		{
+			name:       "null is not a missing value",
+			doc:        `{"a":null}`,
+			patch:      `[{"op":"test","path":"/a","value":false}]`,
+			testFailed: true,
+		},
+		{
+			name:  "missing path is not a test failure",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"test","path":"/b","value":1}]`,
+		},
+		{
+			name:       "a failed test discards earlier operations",
+			doc:        `{"a":1}`,
+			patch:      `[{"op":"replace","path":"/a","value":2},{"op":"test","path":"/a","value":1}]`,
+			testFailed: true,
+		},
+	})
+}
+
+func TestParseJSONPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		ok    bool
+	}{
+		{"empty list", `[]`, true},
+		{"not a list", `{"op":"add","path":"/a","value":1}`, false},
+		{"unknown op", `[{"op":"merge","path":"/a","value":1}]`, false},
+		{"add without value", `[{"op":"add","path":"/a"}]`, false},
+		{"add with null value", `[{"op":"add","path":"/a","value":null}]`, true},
+		{"test without value", `[{"op":"test","path":"/a"}]`, false},
+		{"remove without value", `[{"op":"remove","path":"/a"}]`, true},
+		{"path without slash", `[{"op":"remove","path":"a"}]`, false},
+		{"from without slash", `[{"op":"copy","from":"a","path":"/b"}]`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseJSONPatch([]byte(tt.patch))
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if tt.patch == `[]` && !p.Empty() {
+					t.Error("an empty list must be Empty")
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalid) {
+				t.Fatalf("want ErrInvalid, got %v", err)
+			}
+		})
+	}
+}
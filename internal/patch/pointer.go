@@ -0,0 +1,105 @@
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNotFound     = errors.New("path not found")
+	errNotContainer = errors.New("path does not point into an object or array")
+)
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped reference tokens
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("pointer %q must start with /", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// index parses an array index token, it must be below limit and have no leading zeros
+func index(token string, limit int) (int, error) {
+	i, err := strconv.ParseUint(token, 10, 31)
+	if err != nil || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if int(i) >= limit {
+		return 0, errNotFound
+	}
+	return int(i), nil
+}
+
+func child(container any, token string) (any, error) {
+	switch c := container.(type) {
+	case map[string]any:
+		v, ok := c[token]
+		if !ok {
+			return nil, errNotFound
+		}
+		return v, nil
+	case []any:
+		i, err := index(token, len(c))
+		if err != nil {
+			return nil, err
+		}
+		return c[i], nil
+	}
+	return nil, errNotContainer
+}
+
+func get(doc any, path []string) (any, error) {
+	for _, token := range path {
+		var err error
+		if doc, err = child(doc, token); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// set replaces an existing member of a container
+func set(container any, token string, value any) (any, error) {
+	switch c := container.(type) {
+	case map[string]any:
+		if _, ok := c[token]; !ok {
+			return nil, errNotFound
+		}
+		c[token] = value
+		return c, nil
+	case []any:
+		i, err := index(token, len(c))
+		if err != nil {
+			return nil, err
+		}
+		c[i] = value
+		return c, nil
+	}
+	return nil, errNotContainer
+}
+
+// update walks to the container holding the last token and lets fn change it; arrays may
+// be reallocated, so every container on the way is stored back into its parent
+func update(doc any, path []string, fn func(container any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	next, err := child(doc, path[0])
+	if err != nil {
+		return nil, err
+	}
+	changed, err := update(next, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	return set(doc, path[0], changed)
+}
@@ -0,0 +1,145 @@
+// Package ratelimit implements in-memory token buckets keyed by caller.
+package ratelimit
+
+import (
+	"jobProject/internal/config"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that refilled completely are dropped
+const sweepInterval = time.Minute
+
+// authFailuresPolicy names the buckets counting failed authentication attempts
+const authFailuresPolicy = "auth_failures"
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  config.RateLimit
+}
+
+// refill adds the tokens earned since the last call
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+	}
+	b.last = now
+}
+
+// Result describes the bucket after a request was counted
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is the wait until the next token, zero when allowed
+	RetryAfter time.Duration
+	// Reset is the wait until the bucket is full again
+	Reset time.Duration
+}
+
+type Limiter struct {
+	read         config.RateLimit
+	write        config.RateLimit
+	routes       map[string]config.RateLimit
+	authFailures config.RateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func New(cfg config.RateLimitConfig) *Limiter {
+	return &Limiter{
+		read:         cfg.Read,
+		write:        cfg.Write,
+		routes:       cfg.Routes,
+		authFailures: cfg.AuthFailures,
+		buckets:      map[string]*bucket{},
+		lastSweep:    time.Now(),
+		now:          time.Now,
+	}
+}
+
+// policy picks the route override for the mux pattern, or the read or write limit by method
+func (l *Limiter) policy(route, method string) (string, config.RateLimit) {
+	if limit, ok := l.routes[route]; ok {
+		return route, limit
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return "read", l.read
+	}
+	return "write", l.write
+}
+
+// Allow takes a token from the bucket of key under the limit of the route
+func (l *Limiter) Allow(route, method, key string) Result {
+	name, limit := l.policy(route, method)
+	return l.take(name, limit, key, true)
+}
+
+// AuthAllowed reports whether key has failed authentication too often to try again,
+// without taking a token
+func (l *Limiter) AuthAllowed(key string) Result {
+	return l.take(authFailuresPolicy, l.authFailures, key, false)
+}
+
+// AuthFailed takes a token from the authentication failure bucket of key
+func (l *Limiter) AuthFailed(key string) Result {
+	return l.take(authFailuresPolicy, l.authFailures, key, true)
+}
+
+// take refills the bucket of key under the named policy and takes a token when consume is
+// set; a look without consuming never creates a bucket
+func (l *Limiter) take(name string, limit config.RateLimit, key string, consume bool) Result {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	id := name + "|" + key
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		if consume {
+			l.buckets[id] = b
+		}
+	}
+	b.refill(now)
+
+	res := Result{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		if consume {
+			b.tokens--
+		}
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+	return res
+}
+
+// sweep drops the buckets that are full again, they behave exactly like new ones
+func (l *Limiter) sweep(now time.Time) {
+	for id, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, id)
+		}
+	}
+	l.lastSweep = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
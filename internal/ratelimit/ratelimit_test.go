@@ -0,0 +1,186 @@
+package ratelimit
+
+import (
+	"jobProject/internal/config"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeClock is advanced by hand so refills are exact
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(cfg config.RateLimitConfig) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New(cfg)
+	l.now = clock.now
+	l.lastSweep = clock.t
+	return l, clock
+}
+
+var testConfig = config.RateLimitConfig{
+	Read:         config.RateLimit{Rate: 2, Burst: 4},
+	Write:        config.RateLimit{Rate: 1, Burst: 2},
+	AuthFailures: config.RateLimit{Rate: 0.5, Burst: 2},
+	Routes: map[string]config.RateLimit{
+		"GET /api/v1/subscriptions": {Rate: 1, Burst: 1},
+	},
+}
+
+func TestAllowBurstAndRefill(t *testing.T) {
+	l, clock := newTestLimiter(testConfig)
+	const route = "GET /api/v1/subscriptions/{id}"
+
+	for i := 0; i < 4; i++ {
+		res := l.Allow(route, http.MethodGet, "ip:1")
+		if !res.Allowed {
+			t.Fatalf("request %d within the burst was rejected", i)
+		}
+		if res.Limit != 4 || res.Remaining != 3-i {
+			t.Errorf("request %d: limit %d remaining %d, want 4 and %d", i, res.Limit, res.Remaining, 3-i)
+		}
+	}
+
+	res := l.Allow(route, http.MethodGet, "ip:1")
+	if res.Allowed {
+		t.Fatal("request beyond the burst was allowed")
+	}
+	if res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("retry after %v, want 500ms at 2 tokens per second", res.RetryAfter)
+	}
+	if res.Reset != 2*time.Second {
+		t.Errorf("reset %v, want 2s to refill 4 tokens", res.Reset)
+	}
+
+	clock.advance(250 * time.Millisecond)
+	if l.Allow(route, http.MethodGet, "ip:1").Allowed {
+		t.Fatal("half a token was enough")
+	}
+	clock.advance(250 * time.Millisecond)
+	if !l.Allow(route, http.MethodGet, "ip:1").Allowed {
+		t.Fatal("a refilled token was not available")
+	}
+
+	// a long pause refills at most the burst
+	clock.advance(time.Hour)
+	for i := 0; i < 4; i++ {
+		if !l.Allow(route, http.MethodGet, "ip:1").Allowed {
+			t.Fatalf("request %d after a long pause was rejected", i)
+		}
+	}
+	if l.Allow(route, http.MethodGet, "ip:1").Allowed {
+		t.Fatal("the bucket refilled beyond its burst")
+	}
+}
+
+func TestAllowPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  string
+		method string
+		burst  int
+	}{
+		{"GET reads", "GET /api/v1/subscriptions/{id}", http.MethodGet, 4},
+		{"HEAD reads", "GET /api/v1/subscriptions/{id}", http.MethodHead, 4},
+		{"POST writes", "POST /api/v1/subscriptions", http.MethodPost, 2},
+		{"DELETE writes", "DELETE /api/v1/subscriptions/{id}", http.MethodDelete, 2},
+		{"route override", "GET /api/v1/subscriptions", http.MethodGet, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := newTestLimiter(testConfig)
+			for i := 0; i < tt.burst; i++ {
+				if !l.Allow(tt.route, tt.method, "ip:1").Allowed {
+					t.Fatalf("request %d within the burst of %d was rejected", i, tt.burst)
+				}
+			}
+			if l.Allow(tt.route, tt.method, "ip:1").Allowed {
+				t.Fatalf("request beyond the burst of %d was allowed", tt.burst)
+			}
+		})
+	}
+}
+
+func TestAllowKeysAndPoliciesAreSeparate(t *testing.T) {
+	l, _ := newTestLimiter(testConfig)
+	const route = "POST /api/v1/subscriptions"
+
+	for i := 0; i < 2; i++ {
+		l.Allow(route, http.MethodPost, "ip:1")
+	}
+	if l.Allow(route, http.MethodPost, "ip:1").Allowed {
+		t.Fatal("the write bucket of ip:1 should be empty")
+	}
+	if !l.Allow(route, http.MethodPost, "ip:2").Allowed {
+		t.Error("another key shares the bucket")
+	}
+	if !l.Allow("GET /api/v1/subscriptions/{id}", http.MethodGet, "ip:1").Allowed {
+		t.Error("reads share the bucket of writes")
+	}
+}
+
+func TestAuthFailures(t *testing.T) {
+	l, clock := newTestLimiter(testConfig)
+
+	if !l.AuthAllowed("10.0.0.1").Allowed {
+		t.Fatal("a new client is throttled")
+	}
+	if len(l.buckets) != 0 {
+		t.Fatalf("looking at a bucket created %d buckets", len(l.buckets))
+	}
+
+	l.AuthFailed("10.0.0.1")
+	if !l.AuthAllowed("10.0.0.1").Allowed {
+		t.Fatal("throttled before the burst of failures was spent")
+	}
+	l.AuthFailed("10.0.0.1")
+	res := l.AuthAllowed("10.0.0.1")
+	if res.Allowed {
+		t.Fatal("not throttled after the burst of failures")
+	}
+	if res.RetryAfter != 2*time.Second {
+		t.Errorf("retry after %v, want 2s at 0.5 tokens per second", res.RetryAfter)
+	}
+	if !l.AuthAllowed("10.0.0.2").Allowed {
+		t.Error("another client is throttled")
+	}
+
+	// looking does not spend tokens
+	clock.advance(2 * time.Second)
+	for i := 0; i < 3; i++ {
+		if !l.AuthAllowed("10.0.0.1").Allowed {
+			t.Fatal("a refilled token was spent by looking")
+		}
+	}
+}
+
+func TestSweepDropsFullBuckets(t *testing.T) {
+	l, clock := newTestLimiter(testConfig)
+	const route = "GET /api/v1/subscriptions/{id}"
+
+	l.Allow(route, http.MethodGet, "ip:idle")
+	for i := 0; i < 4; i++ {
+		l.Allow(route, http.MethodGet, "ip:busy")
+	}
+
+	// after a sweep interval ip:idle is full again, ip:busy keeps being drained
+	clock.advance(sweepInterval - time.Second)
+	for i := 0; i < 4; i++ {
+		l.Allow(route, http.MethodGet, "ip:busy")
+	}
+	clock.advance(time.Second)
+	l.Allow(route, http.MethodGet, "ip:busy")
+
+	if _, ok := l.buckets["read|ip:idle"]; ok {
+		t.Error("the full bucket of ip:idle was not swept")
+	}
+	if _, ok := l.buckets["read|ip:busy"]; !ok {
+		t.Error("the bucket of ip:busy was swept while not full")
+	}
+}
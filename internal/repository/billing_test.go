@@ -0,0 +1,333 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"jobProject/internal/conv"
+	"jobProject/internal/db"
+	"jobProject/internal/model"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testRepo connects to TEST_DATABASE_URL and migrates it; the billing queries are SQL, so
+// without a database there is nothing to test
+func testRepo(t *testing.T) *PostgresSubs {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := db.MigrateUp(context.Background(), conn); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return &PostgresSubs{DB: conn}
+}
+
+// testUser is a fresh user_id, so the rows of a test are apart from anything else in the database
+func testUser(t *testing.T, r *PostgresSubs) string {
+	t.Helper()
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		t.Fatal(err)
+	}
+	user := fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	t.Cleanup(func() {
+		ctx := context.Background()
+		if _, err := r.DB.ExecContext(ctx, `DELETE FROM subs_history WHERE subscription_id IN (SELECT id FROM subs_table WHERE user_id = $1)`, user); err != nil {
+			t.Errorf("cleanup history: %v", err)
+		}
+		if _, err := r.DB.ExecContext(ctx, `DELETE FROM subs_table WHERE user_id = $1`, user); err != nil {
+			t.Errorf("cleanup subscriptions: %v", err)
+		}
+	})
+	return user
+}
+
+func testMonth(t *testing.T, mmyyyy string) time.Time {
+	t.Helper()
+	m, err := conv.ParseMMYYYY(mmyyyy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+type billedSub struct {
+	service    string
+	price      int
+	start, end string
+	// pausedFrom is the open pause, pauses the finished ones as [from, resumed) pairs
+	pausedFrom string
+	pauses     [][2]string
+}
+
+// createBilled stores subs for user and returns their ids
+func createBilled(t *testing.T, r *PostgresSubs, user string, subs []billedSub) []int {
+	t.Helper()
+	ids := make([]int, 0, len(subs))
+	for _, b := range subs {
+		var end *time.Time
+		if b.end != "" {
+			m := testMonth(t, b.end)
+			end = &m
+		}
+		var id int
+		err := r.DB.QueryRowContext(context.Background(),
+			`INSERT INTO subs_table (service, price, user_id, start_date, end_date) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+			b.service, b.price, user, testMonth(t, b.start), end).Scan(&id)
+		if err != nil {
+			t.Fatalf("create %+v: %v", b, err)
+		}
+		if b.pausedFrom != "" {
+			if _, err := r.DB.ExecContext(context.Background(), `UPDATE subs_table SET paused_from = $2 WHERE id = $1`, id, testMonth(t, b.pausedFrom)); err != nil {
+				t.Fatalf("pause %+v: %v", b, err)
+			}
+		}
+		for _, p := range b.pauses {
+			_, err := r.DB.ExecContext(context.Background(), `INSERT INTO subs_pauses (subscription_id, paused_from, resumed_from) VALUES ($1, $2, $3)`,
+				id, testMonth(t, p[0]), testMonth(t, p[1]))
+			if err != nil {
+				t.Fatalf("finished pause %+v: %v", b, err)
+			}
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// The window 03-2025..12-2025 bills, month by month:
+//
+//	            03    04    05    06    07    08    09    10    11    12
+//	Netflix A  100   100   100   100
+//	Netflix B                                                  10    10
+//	Spotify D 1000  1000  1000  1000  1000  1000  1000  1000  1000  1000
+//
+// Netflix C is billed through 2024 only
+var billingFixture = []billedSub{
+	{service: "Netflix", price: 100, start: "01-2025", end: "06-2025"},
+	{service: "Netflix", price: 10, start: "11-2025"},
+	{service: "Netflix", price: 5000, start: "01-2024", end: "12-2024"},
+	{service: "Spotify", price: 1000, start: "01-2025"},
+}
+
+func TestTotalPriceProration(t *testing.T) {
+	r := testRepo(t)
+	user := testUser(t, r)
+	createBilled(t, r, user, billingFixture)
+	ctx := context.Background()
+	from, to := testMonth(t, "03-2025"), testMonth(t, "12-2025")
+
+	tests := []struct {
+		name     string
+		service  string
+		from, to time.Time
+		want     int
+	}{
+		{name: "every service", from: from, to: to, want: 4*100 + 2*10 + 10*1000},
+		{name: "started before the window", service: "Netflix", from: from, to: to, want: 4*100 + 2*10},
+		{name: "open-ended up to the window end", service: "Spotify", from: from, to: to, want: 10 * 1000},
+		{name: "single month", service: "Netflix", from: testMonth(t, "04-2025"), to: testMonth(t, "04-2025"), want: 100},
+		{name: "end month is billed", service: "Netflix", from: testMonth(t, "06-2025"), to: testMonth(t, "06-2025"), want: 100},
+		{name: "between subscriptions", service: "Netflix", from: testMonth(t, "07-2025"), to: testMonth(t, "10-2025"), want: 0},
+		{name: "whole subscription", service: "Netflix", from: testMonth(t, "01-2024"), to: testMonth(t, "12-2024"), want: 12 * 5000},
+		{name: "across a year boundary", service: "Netflix", from: testMonth(t, "12-2024"), to: testMonth(t, "01-2025"), want: 5000 + 100},
+		{name: "before every subscription", service: "Netflix", from: testMonth(t, "01-2023"), to: testMonth(t, "12-2023"), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.TotalPriceByPeriod(ctx, user, tt.service, tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTotalPriceBreakdown(t *testing.T) {
+	r := testRepo(t)
+	user := testUser(t, r)
+	createBilled(t, r, user, billingFixture)
+	ctx := context.Background()
+	from, to := testMonth(t, "03-2025"), testMonth(t, "12-2025")
+
+	tests := []struct {
+		groupBy string
+		want    []model.PriceBreakdown
+	}{
+		{model.GroupByService, []model.PriceBreakdown{{Key: "Netflix", Total: 420}, {Key: "Spotify", Total: 10000}}},
+		{model.GroupByUser, []model.PriceBreakdown{{Key: user, Total: 10420}}},
+		{model.GroupByMonth, []model.PriceBreakdown{
+			{Key: "03-2025", Total: 1100}, {Key: "04-2025", Total: 1100}, {Key: "05-2025", Total: 1100}, {Key: "06-2025", Total: 1100},
+			{Key: "07-2025", Total: 1000}, {Key: "08-2025", Total: 1000}, {Key: "09-2025", Total: 1000}, {Key: "10-2025", Total: 1000},
+			{Key: "11-2025", Total: 1010}, {Key: "12-2025", Total: 1010},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			got, err := r.TotalPriceBreakdown(ctx, user, "", tt.groupBy, from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMonthlySpend(t *testing.T) {
+	r := testRepo(t)
+	user := testUser(t, r)
+	createBilled(t, r, user, billingFixture)
+
+	got, err := r.MonthlySpend(context.Background(), user, testMonth(t, "12-2024"), testMonth(t, "07-2025"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.MonthlySpend{
+		{Month: "12-2024", Total: 5000, ActiveCount: 1},
+		{Month: "01-2025", Total: 1100, ActiveCount: 2},
+		{Month: "02-2025", Total: 1100, ActiveCount: 2},
+		{Month: "03-2025", Total: 1100, ActiveCount: 2},
+		{Month: "04-2025", Total: 1100, ActiveCount: 2},
+		{Month: "05-2025", Total: 1100, ActiveCount: 2},
+		{Month: "06-2025", Total: 1100, ActiveCount: 2},
+		{Month: "07-2025", Total: 1000, ActiveCount: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// The window 03-2025..12-2025 bills, month by month:
+//
+//	            03    04    05    06    07    08    09    10    11    12
+//	Netflix    100   100   100   100
+//	Spotify   1000  1000  (paused from 05-2025)
+//	Yandex       7  (paused 04-06)        7     7     7     7     7     7
+var pausedFixture = []billedSub{
+	{service: "Netflix", price: 100, start: "01-2025", end: "06-2025"},
+	{service: "Spotify", price: 1000, start: "01-2025", pausedFrom: "05-2025"},
+	{service: "Yandex", price: 7, start: "01-2025", end: "12-2025", pauses: [][2]string{{"04-2025", "07-2025"}}},
+}
+
+func TestPausedBilling(t *testing.T) {
+	r := testRepo(t)
+	user := testUser(t, r)
+	createBilled(t, r, user, pausedFixture)
+	ctx := context.Background()
+	from, to := testMonth(t, "03-2025"), testMonth(t, "12-2025")
+
+	totals := []struct {
+		name     string
+		service  string
+		from, to time.Time
+		want     int
+	}{
+		{name: "every service", from: from, to: to, want: 4*100 + 2*1000 + 7*7},
+		{name: "open pause", service: "Spotify", from: from, to: to, want: 2 * 1000},
+		{name: "finished pause", service: "Yandex", from: from, to: to, want: 7 * 7},
+		{name: "single month inside the pause", service: "Yandex", from: testMonth(t, "05-2025"), to: testMonth(t, "05-2025"), want: 0},
+		{name: "resume month is billed", service: "Yandex", from: testMonth(t, "07-2025"), to: testMonth(t, "07-2025"), want: 7},
+	}
+	for _, tt := range totals {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.TotalPriceByPeriod(ctx, user, tt.service, tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("breakdown by month", func(t *testing.T) {
+		got, err := r.TotalPriceBreakdown(ctx, user, "", model.GroupByMonth, from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []model.PriceBreakdown{
+			{Key: "03-2025", Total: 1107}, {Key: "04-2025", Total: 1100}, {Key: "05-2025", Total: 100}, {Key: "06-2025", Total: 100},
+			{Key: "07-2025", Total: 7}, {Key: "08-2025", Total: 7}, {Key: "09-2025", Total: 7}, {Key: "10-2025", Total: 7},
+			{Key: "11-2025", Total: 7}, {Key: "12-2025", Total: 7},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("monthly spend", func(t *testing.T) {
+		got, err := r.MonthlySpend(ctx, user, testMonth(t, "03-2025"), testMonth(t, "07-2025"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []model.MonthlySpend{
+			{Month: "03-2025", Total: 1107, ActiveCount: 3},
+			{Month: "04-2025", Total: 1100, ActiveCount: 2},
+			{Month: "05-2025", Total: 100, ActiveCount: 1},
+			{Month: "06-2025", Total: 100, ActiveCount: 1},
+			{Month: "07-2025", Total: 7, ActiveCount: 1},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestActiveStats(t *testing.T) {
+	r := testRepo(t)
+	ctx := context.Background()
+	month := testMonth(t, "05-2025")
+
+	// other rows of the database count too, so only the difference is checked
+	before, err := r.ActiveStats(ctx, month)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createBilled(t, r, testUser(t, r), pausedFixture)
+	after, err := r.ActiveStats(ctx, month)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := (model.ActiveStats{Count: after.Count - before.Count, MRR: after.MRR - before.MRR}); got != (model.ActiveStats{Count: 1, MRR: 100}) {
+		t.Errorf("05-2025 added %+v, want only the Netflix subscription", got)
+	}
+}
+
+func TestResumeRecordsPause(t *testing.T) {
+	r := testRepo(t)
+	user := testUser(t, r)
+	ids := createBilled(t, r, user, []billedSub{{service: "Netflix", price: 100, start: "01-2020", pausedFrom: "01-2021"}})
+	ctx := context.Background()
+
+	_, err := r.ApplyLifecycle(ctx, ids[0], "", model.Precondition{}, model.HistoryResume, "", func(old model.SubscriptionDB) (model.SubscriptionDB, error) {
+		old.PausedFrom = nil
+		return old, nil
+	}, model.OverlapReject)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+
+	// the months of the finished pause stay unbilled, the ones before it are billed
+	got, err := r.TotalPriceByPeriod(ctx, user, "", testMonth(t, "11-2020"), testMonth(t, "02-2021"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 2*100 {
+		t.Errorf("got %d, want %d", got, 2*100)
+	}
+}
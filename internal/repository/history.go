@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"jobProject/internal/auth"
+	"jobProject/internal/conv"
+	"jobProject/internal/logger"
+	"jobProject/internal/model"
+	"log"
+)
+
+const (
+	anonymousActor = "anonymous"
+	purgeActor     = "system:purge"
+)
+
+// changeActor names the caller stored with a change and owning its idempotency keys, the method
+// prefix tells API keys and tokens apart
+func changeActor(ctx context.Context) string {
+	if p, ok := auth.FromContext(ctx); ok {
+		return p.Method + ":" + p.Name
+	}
+	return anonymousActor
+}
+
+func stateOf(s *model.SubscriptionDB) []byte {
+	if s == nil {
+		return nil
+	}
+	state := model.SubscriptionState{
+		Service:   s.Service,
+		Price:     s.Price,
+		UserID:    s.UserID,
+		StartDate: conv.FormatMMYYYY(s.StartDate),
+	}
+	if s.EndDate != nil {
+		end := conv.FormatMMYYYY(*s.EndDate)
+		state.EndDate = &end
+	}
+	if s.PausedFrom != nil {
+		paused := conv.FormatMMYYYY(*s.PausedFrom)
+		state.PausedFrom = &paused
+	}
+	body, _ := json.Marshal(state)
+	return body
+}
+
+const recordStatement = `
+	INSERT INTO subs_history (subscription_id, action, old_values, new_values, actor, request_id, reason)
+	VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''))
+`
+
+// recordChange appends a history entry; it must run in the transaction of the change itself
+func recordChange(ctx context.Context, q querier, action string, id int, before, after *model.SubscriptionDB) error {
+	return recordAction(ctx, q, action, "", id, before, after)
+}
+
+// recordAction is recordChange with the reason the caller gave for the change
+func recordAction(ctx context.Context, q querier, action, reason string, id int, before, after *model.SubscriptionDB) error {
+	_, err := q.ExecContext(ctx, recordStatement,
+		id, action, stateOf(before), stateOf(after), changeActor(ctx), logger.RequestID(ctx), reason)
+	if err != nil {
+		return fmt.Errorf("failed to record %s of subscription %d: %w", action, id, err)
+	}
+	return nil
+}
+
+// historyOwnerFilter restricts the history to the entries recording a row of owner before
+// or after the change, an empty owner matches every entry. The condition binds the next
+// placeholder after args
+func historyOwnerFilter(owner string, args []any) (string, []any) {
+	if owner == "" {
+		return "", args
+	}
+	args = append(args, owner)
+	return fmt.Sprintf(` AND (old_values->>'user_id' = $%[1]d OR new_values->>'user_id' = $%[1]d)`, len(args)), args
+}
+
+func (r *PostgresSubs) ListHistory(ctx context.Context, id int, owner string, limit, offset int) (_ []model.HistoryEntry, err error) {
+	cond, args := historyOwnerFilter(owner, []any{id})
+	args = append(args, limit, offset)
+	q := `
+		SELECT id, subscription_id, action, old_values, new_values, actor, COALESCE(request_id, ''), COALESCE(reason, ''), changed_at
+		FROM subs_history WHERE subscription_id = $1` + cond + fmt.Sprintf(` ORDER BY changed_at, id LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+	ctx, span := startSpan(ctx, "ListHistory", q)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := r.DB.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	history := []model.HistoryEntry{}
+	for rows.Next() {
+		var (
+			entry         model.HistoryEntry
+			before, after []byte
+		)
+		if err := rows.Scan(&entry.ID, &entry.SubscriptionID, &entry.Action, &before, &after,
+			&entry.Actor, &entry.RequestID, &entry.Reason, &entry.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan history: %w", err)
+		}
+		if entry.OldValues, err = decodeState(before); err != nil {
+			return nil, err
+		}
+		if entry.NewValues, err = decodeState(after); err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	setRows(span, int64(len(history)))
+	return history, nil
+}
+
+func decodeState(body []byte) (*model.SubscriptionState, error) {
+	if body == nil {
+		return nil, nil
+	}
+	var state model.SubscriptionState
+	if err := json.Unmarshal(body, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode history values: %w", err)
+	}
+	return &state, nil
+}
+
+func (r *PostgresSubs) CountHistory(ctx context.Context, id int, owner string) (_ int, err error) {
+	cond, args := historyOwnerFilter(owner, []any{id})
+	q := `SELECT COUNT(*) FROM subs_history WHERE subscription_id = $1` + cond
+	ctx, span := startSpan(ctx, "CountHistory", q)
+	defer func() { endSpan(span, err) }()
+
+	var count int
+	if err := r.DB.QueryRowContext(ctx, q, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count history: %w", err)
+	}
+	return count, nil
+}
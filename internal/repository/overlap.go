@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"jobProject/internal/model"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrOverlap is returned when a subscription overlaps another one of the same user and service
+var ErrOverlap = errors.New("subscription overlaps an existing one for this user and service")
+
+// exclusion_violation, raised by the subs_no_overlap constraint
+const pqExclusionViolation = "23P01"
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func overlapErr(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == pqExclusionViolation {
+		return ErrOverlap
+	}
+	return err
+}
+
+// mergeOverlaps soft-deletes the rows of the same user and service overlapping [start, end]
+// (except excludeID), records them as merged and returns the range covering all of them.
+// Merged rows can be restored until purged, as long as they no longer overlap
+func mergeOverlaps(ctx context.Context, q querier, excludeID int, userID, service string, start time.Time, end *time.Time) (time.Time, *time.Time, error) {
+	const del = `
+		UPDATE subs_table SET deleted_at = now(), ` + bumpVersion + `
+		WHERE id <> $1 AND user_id = $2 AND service = $3 AND NOT allow_overlap AND deleted_at IS NULL
+			AND daterange(start_date, end_date, '[]') && daterange($4, $5, '[]')
+		RETURNING ` + subColumns
+	rows, err := q.QueryContext(ctx, del, excludeID, userID, service, start, end)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	defer rows.Close()
+
+	var merged []model.SubscriptionDB
+	for rows.Next() {
+		m, err := scanSub(rows)
+		if err != nil {
+			return time.Time{}, nil, err
+		}
+		if m.StartDate.Before(start) {
+			start = m.StartDate
+		}
+		if end != nil && (m.EndDate == nil || m.EndDate.After(*end)) {
+			end = m.EndDate
+		}
+		merged = append(merged, m)
+	}
+	if err := rows.Err(); err != nil {
+		return time.Time{}, nil, err
+	}
+	// the connection is busy until the result set is closed
+	rows.Close()
+
+	for i := range merged {
+		if err := recordChange(ctx, q, model.HistoryMerge, merged[i].ID, &merged[i], nil); err != nil {
+			return time.Time{}, nil, err
+		}
+	}
+	return start, end, nil
+}
+
+// overlapAllowed decides whether a changed row is exempt from the overlap check. The "allow"
+// policy exempts it; a row already exempt, stored under "allow" or before the check existed,
+// stays so unless the change moves it to another user or service or widens its dates, the
+// only changes that can add an overlap
+func overlapAllowed(old, next model.SubscriptionDB, overlap model.OverlapPolicy) bool {
+	if overlap == model.OverlapAllow {
+		return true
+	}
+	if !old.AllowOverlap || next.UserID != old.UserID || next.Service != old.Service || next.StartDate.Before(old.StartDate) {
+		return false
+	}
+	if old.EndDate == nil {
+		return true
+	}
+	return next.EndDate != nil && !next.EndDate.After(*old.EndDate)
+}
+
+const insertStatement = `INSERT INTO subs_table (service, price, user_id, start_date, end_date, allow_overlap) VALUES ($1,$2,$3,$4,$5,$6) RETURNING ` + subColumns
+
+// insertSub stores the subscription according to the overlap policy
+func insertSub(ctx context.Context, q querier, s model.SubscriptionDB, overlap model.OverlapPolicy) (model.SubscriptionDB, error) {
+	if overlap == model.OverlapMerge {
+		var err error
+		s.StartDate, s.EndDate, err = mergeOverlaps(ctx, q, 0, s.UserID, s.Service, s.StartDate, s.EndDate)
+		if err != nil {
+			return model.SubscriptionDB{}, err
+		}
+	}
+
+	created, err := scanSub(q.QueryRowContext(ctx, insertStatement, s.Service, s.Price, s.UserID, s.StartDate, s.EndDate, overlap == model.OverlapAllow))
+	if err != nil {
+		return model.SubscriptionDB{}, overlapErr(err)
+	}
+	if err := recordChange(ctx, q, model.HistoryCreate, created.ID, nil, &created); err != nil {
+		return model.SubscriptionDB{}, err
+	}
+	return created, nil
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"jobProject/internal/model"
+	"testing"
+	"time"
+)
+
+func TestOverlapAllowed(t *testing.T) {
+	month := func(y int, m time.Month) *time.Time {
+		d := time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+		return &d
+	}
+	const user = "60601fee-2bf1-4721-ae6f-7636e79a0cba"
+	exempt := model.SubscriptionDB{Service: "Netflix", UserID: user, StartDate: *month(2025, 3), EndDate: month(2025, 9), AllowOverlap: true}
+	openEnded := exempt
+	openEnded.EndDate = nil
+	checked := exempt
+	checked.AllowOverlap = false
+
+	change := func(old model.SubscriptionDB, fn func(s *model.SubscriptionDB)) model.SubscriptionDB {
+		fn(&old)
+		return old
+	}
+
+	tests := []struct {
+		name    string
+		old     model.SubscriptionDB
+		next    model.SubscriptionDB
+		overlap model.OverlapPolicy
+		want    bool
+	}{
+		{"allow policy exempts any row", checked, checked, model.OverlapAllow, true},
+		{"checked row stays checked", checked, change(checked, func(s *model.SubscriptionDB) { s.Price = 5 }), model.OverlapReject, false},
+		{"price change keeps the exemption", exempt, change(exempt, func(s *model.SubscriptionDB) { s.Price = 5 }), model.OverlapReject, true},
+		{"earlier end keeps the exemption", exempt, change(exempt, func(s *model.SubscriptionDB) { s.EndDate = month(2025, 6) }), model.OverlapReject, true},
+		{"later start keeps the exemption", exempt, change(exempt, func(s *model.SubscriptionDB) { s.StartDate = *month(2025, 4) }), model.OverlapMerge, true},
+		{"ending an open-ended row keeps the exemption", openEnded, change(openEnded, func(s *model.SubscriptionDB) { s.EndDate = month(2026, 1) }), model.OverlapReject, true},
+		{"later end is checked", exempt, change(exempt, func(s *model.SubscriptionDB) { s.EndDate = month(2025, 12) }), model.OverlapReject, false},
+		{"dropping the end is checked", exempt, change(exempt, func(s *model.SubscriptionDB) { s.EndDate = nil }), model.OverlapReject, false},
+		{"earlier start is checked", exempt, change(exempt, func(s *model.SubscriptionDB) { s.StartDate = *month(2025, 1) }), model.OverlapMerge, false},
+		{"another service is checked", exempt, change(exempt, func(s *model.SubscriptionDB) { s.Service = "IVI" }), model.OverlapReject, false},
+		{"another user is checked", exempt, change(exempt, func(s *model.SubscriptionDB) { s.UserID = "70601fee-2bf1-4721-ae6f-7636e79a0cbb" }), model.OverlapReject, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := overlapAllowed(tt.old, tt.next, tt.overlap); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A row exempted from the overlap check, like the ones migration 0003 marks, can be edited
+// under the reject policy as long as the edit cannot add an overlap
+func TestEditExemptOverlap(t *testing.T) {
+	r := testRepo(t)
+	user := testUser(t, r)
+	ctx := context.Background()
+
+	checked, err := r.CreateColumn(ctx, model.SubscriptionDB{Service: "Netflix", Price: 100, UserID: user, StartDate: testMonth(t, "01-2025"), EndDate: ptr(testMonth(t, "12-2025"))}, model.OverlapReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exempt, err := r.CreateColumn(ctx, model.SubscriptionDB{Service: "Netflix", Price: 200, UserID: user, StartDate: testMonth(t, "06-2025")}, model.OverlapAllow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel := func(old model.SubscriptionDB) (model.SubscriptionDB, error) {
+		old.EndDate = ptr(testMonth(t, "09-2025"))
+		return old, nil
+	}
+	cancelled, err := r.ApplyLifecycle(ctx, exempt.ID, "", model.Precondition{}, model.HistoryCancel, "", cancel, model.OverlapReject)
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if !cancelled.AllowOverlap {
+		t.Error("cancel cleared the exemption")
+	}
+
+	reprice := func(old model.SubscriptionDB) (model.SubscriptionDB, error) {
+		old.Price = 250
+		return old, nil
+	}
+	if _, err := r.PatchColumnByID(ctx, exempt.ID, user, model.Precondition{}, reprice, model.OverlapReject); err != nil {
+		t.Fatalf("price change: %v", err)
+	}
+
+	renew := func(old model.SubscriptionDB) (model.SubscriptionDB, error) {
+		old.EndDate = ptr(testMonth(t, "03-2026"))
+		return old, nil
+	}
+	if _, err := r.ApplyLifecycle(ctx, exempt.ID, "", model.Precondition{}, model.HistoryRenew, "", renew, model.OverlapReject); !errors.Is(err, ErrOverlap) {
+		t.Fatalf("renew over %d: want ErrOverlap, got %v", checked.ID, err)
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
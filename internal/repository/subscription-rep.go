@@ -3,127 +3,641 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"jobProject/internal/conv"
 	"jobProject/internal/model"
 	"log"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	// ErrIdempotencyMismatch is returned when an idempotency key is replayed with a different request
+	ErrIdempotencyMismatch = errors.New("idempotency key was used with a different request")
+	// ErrVersionMismatch is returned when the row changed since the version the caller read
+	ErrVersionMismatch = errors.New("subscription was modified concurrently")
+	// ErrIDNotIssued is returned when an upsert names an id that never held a subscription
+	ErrIDNotIssued = errors.New("subscription id was never issued")
 )
 
 type SubsRepository interface {
-	CreateColumn(ctx context.Context, model model.SubscriptionDB) error
-	ReadColumn(ctx context.Context, id int) (model.SubscriptionDB, error)
-	PatchColumnByID(ctx context.Context, id int, s model.Subscription) error
-	DeleteColumnByID(ctx context.Context, id int) error
+	CreateColumn(ctx context.Context, s model.SubscriptionDB, overlap model.OverlapPolicy) (model.SubscriptionDB, error)
+	CreateColumnIdempotent(ctx context.Context, key, requestHash string, s model.SubscriptionDB, overlap model.OverlapPolicy) (model.SubscriptionDB, bool, error)
+	ReadColumn(ctx context.Context, id int, owner string, includeDeleted bool) (model.SubscriptionDB, error)
+	PatchColumnByID(ctx context.Context, id int, owner string, pre model.Precondition, mutate Mutation, overlap model.OverlapPolicy) (model.SubscriptionDB, error)
+	ApplyLifecycle(ctx context.Context, id int, owner string, pre model.Precondition, action, reason string, mutate Mutation, overlap model.OverlapPolicy) (model.SubscriptionDB, error)
+	UpsertColumnByID(ctx context.Context, id int, owner string, s model.SubscriptionDB, replace Mutation, overlap model.OverlapPolicy) (model.SubscriptionDB, bool, error)
+	DeleteColumnByID(ctx context.Context, id int, owner string) error
+	RestoreColumnByID(ctx context.Context, id int, owner string) (model.SubscriptionDB, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+	PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+	ListHistory(ctx context.Context, id int, owner string, limit, offset int) ([]model.HistoryEntry, error)
+	CountHistory(ctx context.Context, id int, owner string) (int, error)
 	TotalPriceByPeriod(ctx context.Context, userID, service string, from, to time.Time) (int, error)
-	ListSubscriptions(ctx context.Context, userID string, limit int, offset int) ([]model.SubscriptionDB, error)
-	CountSubscription(ctx context.Context, userID string) (int, error)
+	TotalPriceBreakdown(ctx context.Context, userID, service, groupBy string, from, to time.Time) ([]model.PriceBreakdown, error)
+	MonthlySpend(ctx context.Context, userID string, from, to time.Time) ([]model.MonthlySpend, error)
+	ListSubscriptions(ctx context.Context, userID string, limit int, offset int, includeDeleted bool) ([]model.SubscriptionDB, error)
+	CountSubscription(ctx context.Context, userID string, includeDeleted bool) (int, error)
+	ActiveStats(ctx context.Context, month time.Time) (model.ActiveStats, error)
 }
 
 type PostgresSubs struct {
 	DB *sql.DB
 }
 
-func (r *PostgresSubs) CreateColumn(ctx context.Context, s model.SubscriptionDB) error {
-	rows, err := r.DB.ExecContext(ctx, `INSERT INTO subs_table (service, price, user_id, start_date, end_date) VALUES ($1,$2,$3,$4,$5)`, s.Service, s.Price, s.UserID, s.StartDate, s.EndDate)
+// notDeleted hides soft-deleted rows
+const notDeleted = ` AND deleted_at IS NULL`
+
+// subColumns is the column list read by scanSub
+const subColumns = `id, service, price, user_id, start_date, end_date, paused_from, allow_overlap, deleted_at, version, updated_at`
+
+// bumpVersion is appended to the SET clause of every statement changing a row
+const bumpVersion = `version = version + 1, updated_at = now()`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSub(row rowScanner) (model.SubscriptionDB, error) {
+	var s model.SubscriptionDB
+	err := row.Scan(&s.ID, &s.Service, &s.Price, &s.UserID, &s.StartDate, &s.EndDate, &s.PausedFrom, &s.AllowOverlap, &s.DeletedAt, &s.Version, &s.UpdatedAt)
+	return s, err
+}
+
+// ownerFilter restricts a single-row query to the rows of owner, an empty owner
+// matches every row. The condition binds the next placeholder after args
+func ownerFilter(owner string, args []any) (string, []any) {
+	if owner == "" {
+		return "", args
+	}
+	args = append(args, owner)
+	return fmt.Sprintf(` AND user_id = $%d`, len(args)), args
+}
+
+func (r *PostgresSubs) CreateColumn(ctx context.Context, s model.SubscriptionDB, overlap model.OverlapPolicy) (_ model.SubscriptionDB, err error) {
+	ctx, span := startSpan(ctx, "CreateColumn", insertStatement)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return model.SubscriptionDB{}, err
+	}
+	defer tx.Rollback()
+
+	created, err := insertSub(ctx, tx, s, overlap)
 	if err != nil {
 		log.Printf("insert error: %v", err)
-		return err
+		return model.SubscriptionDB{}, err
 	}
-	n, _ := rows.RowsAffected()
-	log.Printf("inserted rows: %d", n)
-	return nil
+	if err := tx.Commit(); err != nil {
+		return model.SubscriptionDB{}, overlapErr(err)
+	}
+	log.Printf("inserted row id: %d", created.ID)
+	return created, nil
 }
 
-func (r *PostgresSubs) ReadColumn(ctx context.Context, id int) (model.SubscriptionDB, error) {
-	const q = `SELECT id, service, price, user_id, start_date, end_date FROM subs_table WHERE id = $1`
-	var s model.SubscriptionDB
-	err := r.DB.QueryRowContext(ctx, q, id).Scan(
-		&s.ID, &s.Service, &s.Price, &s.UserID, &s.StartDate, &s.EndDate,
-	)
+// CreateColumnIdempotent inserts the subscription once per key of the calling principal. A replay
+// with the same request hash returns the originally stored subscription and true; concurrent
+// requests with the same key wait on the key's row lock until the first one commits
+func (r *PostgresSubs) CreateColumnIdempotent(ctx context.Context, key, requestHash string, s model.SubscriptionDB, overlap model.OverlapPolicy) (_ model.SubscriptionDB, _ bool, err error) {
+	ctx, span := startSpan(ctx, "CreateColumnIdempotent", insertStatement)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return model.SubscriptionDB{}, false, err
+	}
+	defer tx.Rollback()
+
+	scope := changeActor(ctx)
+	const claim = `INSERT INTO idempotency_keys (scope, key, request_hash) VALUES ($1, $2, $3) ON CONFLICT (scope, key) DO NOTHING`
+	res, err := tx.ExecContext(ctx, claim, scope, key, requestHash)
+	if err != nil {
+		return model.SubscriptionDB{}, false, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	claimed, err := res.RowsAffected()
+	if err != nil {
+		return model.SubscriptionDB{}, false, err
+	}
+
+	if claimed == 0 {
+		const stored = `SELECT request_hash, response FROM idempotency_keys WHERE scope = $1 AND key = $2`
+		var (
+			storedHash string
+			response   []byte
+		)
+		if err := tx.QueryRowContext(ctx, stored, scope, key).Scan(&storedHash, &response); err != nil {
+			return model.SubscriptionDB{}, false, fmt.Errorf("failed to read idempotency key: %w", err)
+		}
+		if storedHash != requestHash || response == nil {
+			return model.SubscriptionDB{}, false, ErrIdempotencyMismatch
+		}
+		var original model.SubscriptionDB
+		if err := json.Unmarshal(response, &original); err != nil {
+			return model.SubscriptionDB{}, false, fmt.Errorf("failed to decode stored response: %w", err)
+		}
+		span.SetAttributes(attribute.Bool("idempotency.replayed", true))
+		return original, true, nil
+	}
+
+	created, err := insertSub(ctx, tx, s, overlap)
+	if err != nil {
+		log.Printf("insert error: %v", err)
+		return model.SubscriptionDB{}, false, err
+	}
+
+	response, err := json.Marshal(created)
+	if err != nil {
+		return model.SubscriptionDB{}, false, err
+	}
+	const save = `UPDATE idempotency_keys SET response = $3 WHERE scope = $1 AND key = $2`
+	if _, err := tx.ExecContext(ctx, save, scope, key, response); err != nil {
+		return model.SubscriptionDB{}, false, fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.SubscriptionDB{}, false, overlapErr(err)
+	}
+	log.Printf("inserted row id: %d with an idempotency key", created.ID)
+	return created, false, nil
+}
+
+func (r *PostgresSubs) ReadColumn(ctx context.Context, id int, owner string, includeDeleted bool) (_ model.SubscriptionDB, err error) {
+	cond, args := ownerFilter(owner, []any{id})
+	if !includeDeleted {
+		cond += notDeleted
+	}
+	q := `SELECT ` + subColumns + ` FROM subs_table WHERE id = $1` + cond
+	ctx, span := startSpan(ctx, "ReadColumn", q)
+	defer func() { endSpan(span, err) }()
+
+	s, err := scanSub(r.DB.QueryRowContext(ctx, q, args...))
 	if errors.Is(err, sql.ErrNoRows) {
 		return model.SubscriptionDB{}, sql.ErrNoRows
 	}
 	if err != nil {
 		return model.SubscriptionDB{}, err
 	}
+	setRows(span, 1)
 	return s, nil
 }
 
-func (r *PostgresSubs) PatchColumnByID(ctx context.Context, id int, s model.Subscription) error {
-	const q = `SELECT id, service, price, user_id, start_date, end_date FROM subs_table WHERE id = $1`
-	var old model.SubscriptionDB
-	err := r.DB.QueryRowContext(ctx, q, id).Scan(
-		&old.ID, &old.Service, &old.Price, &old.UserID, &old.StartDate, &old.EndDate,
-	)
+// Mutation computes the new state of a subscription from the stored one. It runs while
+// the row is locked, its errors are returned to the caller unchanged
+type Mutation func(old model.SubscriptionDB) (model.SubscriptionDB, error)
+
+const updateStatement = `UPDATE subs_table SET service = $1, price = $2, user_id = $3, start_date = $4, end_date = $5, allow_overlap = $6, paused_from = $7, ` +
+	bumpVersion + ` WHERE id = $8 RETURNING ` + subColumns
+
+// PatchColumnByID applies mutate in one transaction holding the row lock, so concurrent
+// patches are serialized instead of overwriting each other. The stored version must match
+// pre, otherwise ErrVersionMismatch is returned and nothing changes
+func (r *PostgresSubs) PatchColumnByID(ctx context.Context, id int, owner string, pre model.Precondition, mutate Mutation, overlap model.OverlapPolicy) (_ model.SubscriptionDB, err error) {
+	ctx, span := startSpan(ctx, "PatchColumnByID", updateStatement)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return model.SubscriptionDB{}, err
+	}
+	defer tx.Rollback()
+
+	updated, err := mutateLocked(ctx, tx, id, owner, pre, model.HistoryUpdate, "", mutate, overlap)
+	if err != nil {
+		return model.SubscriptionDB{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return model.SubscriptionDB{}, overlapErr(err)
+	}
+	return updated, nil
+}
+
+// ApplyLifecycle is PatchColumnByID for the lifecycle actions, the change is recorded
+// under action together with the reason given by the caller
+func (r *PostgresSubs) ApplyLifecycle(ctx context.Context, id int, owner string, pre model.Precondition, action, reason string, mutate Mutation, overlap model.OverlapPolicy) (_ model.SubscriptionDB, err error) {
+	ctx, span := startSpan(ctx, "ApplyLifecycle", updateStatement)
+	span.SetAttributes(attribute.String("subscription.action", action))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return model.SubscriptionDB{}, err
+	}
+	defer tx.Rollback()
+
+	updated, err := mutateLocked(ctx, tx, id, owner, pre, action, reason, mutate, overlap)
+	if err != nil {
+		return model.SubscriptionDB{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return model.SubscriptionDB{}, overlapErr(err)
+	}
+	return updated, nil
+}
+
+// mutateLocked locks the live row, applies mutate and stores the result with its history entry
+func mutateLocked(ctx context.Context, tx *sql.Tx, id int, owner string, pre model.Precondition, action, reason string, mutate Mutation, overlap model.OverlapPolicy) (model.SubscriptionDB, error) {
+	cond, args := ownerFilter(owner, []any{id})
+	q := `SELECT ` + subColumns + ` FROM subs_table WHERE id = $1` + cond + notDeleted + ` FOR UPDATE`
+
+	// the row stays locked until commit so the history records the values actually replaced
+	old, err := scanSub(tx.QueryRowContext(ctx, q, args...))
 	if errors.Is(err, sql.ErrNoRows) {
-		return sql.ErrNoRows
+		// If-Match: * requires a current subscription, without one the precondition fails
+		if pre.Any {
+			return model.SubscriptionDB{}, ErrVersionMismatch
+		}
+		return model.SubscriptionDB{}, sql.ErrNoRows
+	}
+	if err != nil {
+		return model.SubscriptionDB{}, err
+	}
+	if !pre.Matches(old.Version) {
+		return model.SubscriptionDB{}, ErrVersionMismatch
 	}
 
-	if s.Service == nil {
-		s.Service = &old.Service
+	s, err := mutate(old)
+	if err != nil {
+		return model.SubscriptionDB{}, err
 	}
-	if s.Price == nil {
-		s.Price = &old.Price
+
+	allow := overlapAllowed(old, s, overlap)
+	if overlap == model.OverlapMerge && !allow {
+		s.StartDate, s.EndDate, err = mergeOverlaps(ctx, tx, id, s.UserID, s.Service, s.StartDate, s.EndDate)
+		if err != nil {
+			return model.SubscriptionDB{}, err
+		}
+	}
+
+	updated, err := scanSub(tx.QueryRowContext(ctx, updateStatement, s.Service, s.Price, s.UserID, s.StartDate, s.EndDate, allow, s.PausedFrom, id))
+	if err != nil {
+		return model.SubscriptionDB{}, overlapErr(err)
 	}
-	if s.UserID == nil {
-		s.UserID = &old.UserID
+	if old.PausedFrom != nil && updated.PausedFrom == nil {
+		if err := recordPause(ctx, tx, id, *old.PausedFrom); err != nil {
+			return model.SubscriptionDB{}, err
+		}
 	}
-	var timeS *time.Time
-	if s.StartDate == nil && old.StartDate != (time.Time{}) {
-		timeS = &old.StartDate
-	} else if s.StartDate != nil {
-		parsed, _ := conv.ParseMMYYYY(*s.StartDate)
-		timeS = &parsed
+	if err := recordAction(ctx, tx, action, reason, id, &old, &updated); err != nil {
+		return model.SubscriptionDB{}, err
 	}
-	var timeE *time.Time
-	if s.EndDate == nil {
-		timeE = old.EndDate
-	} else if s.EndDate != nil {
-		parsed, _ := conv.ParseMMYYYY(*s.EndDate)
-		timeE = &parsed
+	return updated, nil
+}
+
+// recordPause keeps the pause from pausedFrom, which ends with the current month, out of the
+// bills of the past. A pause that has not begun yet covers nothing and is dropped
+func recordPause(ctx context.Context, tx *sql.Tx, id int, pausedFrom time.Time) error {
+	const q = `
+		INSERT INTO subs_pauses (subscription_id, paused_from, resumed_from)
+		SELECT $1, $2::date, resumed.m FROM (SELECT date_trunc('month', now() AT TIME ZONE 'UTC')::date AS m) AS resumed
+		WHERE resumed.m > $2::date
+	`
+	if _, err := tx.ExecContext(ctx, q, id, pausedFrom); err != nil {
+		return fmt.Errorf("failed to record pause of subscription %d: %w", id, err)
 	}
-	const q1 = `UPDATE subs_table SET service = $1, price = $2, user_id = $3, start_date = $4, end_date = $5 WHERE id = $6`
-	_, err = r.DB.ExecContext(ctx, q1, *s.Service, *s.Price, *s.UserID, timeS, timeE, id)
+	return nil
+}
+
+// UpsertColumnByID replaces the live subscription with the given id by the result of replace
+// or, when no row has that id, inserts s under it; the bool reports whether it was created.
+// A soft-deleted or foreign row with the id is reported as sql.ErrNoRows. Only ids that
+// once held a subscription can be created again, any other is ErrIDNotIssued: the sequence
+// owns new ids. A purged id goes back only to the owner its whole history belongs to, for
+// anyone else it is sql.ErrNoRows too
+func (r *PostgresSubs) UpsertColumnByID(ctx context.Context, id int, owner string, s model.SubscriptionDB, replace Mutation, overlap model.OverlapPolicy) (_ model.SubscriptionDB, _ bool, err error) {
+	const insert = `INSERT INTO subs_table (id, service, price, user_id, start_date, end_date, allow_overlap) VALUES ($1,$2,$3,$4,$5,$6,$7)
+		ON CONFLICT (id) DO NOTHING RETURNING ` + subColumns
+	// the history outlives purged rows, so it knows every id that was ever committed;
+	// ids the sequence is still handing out or will hand out later are not there yet
+	const issued = `
+		SELECT EXISTS (SELECT 1 FROM subs_table WHERE id = $1),
+			EXISTS (SELECT 1 FROM subs_history WHERE subscription_id = $1),
+			EXISTS (SELECT 1 FROM subs_history WHERE subscription_id = $1 AND $2 <> ''
+				AND (old_values->>'user_id' <> $2 OR new_values->>'user_id' <> $2))
+	`
+	ctx, span := startSpan(ctx, "UpsertColumnByID", insert)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return model.SubscriptionDB{}, false, err
+	}
+	defer tx.Rollback()
+
+	var live, recorded, foreign bool
+	if err := tx.QueryRowContext(ctx, issued, id, owner).Scan(&live, &recorded, &foreign); err != nil {
+		return model.SubscriptionDB{}, false, err
+	}
+	if !live && !recorded {
+		return model.SubscriptionDB{}, false, ErrIDNotIssued
+	}
+	if !live && foreign {
+		return model.SubscriptionDB{}, false, sql.ErrNoRows
+	}
+
+	ins := s
+	if overlap == model.OverlapMerge {
+		ins.StartDate, ins.EndDate, err = mergeOverlaps(ctx, tx, id, s.UserID, s.Service, s.StartDate, s.EndDate)
+		if err != nil {
+			return model.SubscriptionDB{}, false, err
+		}
+	}
+
+	created, err := scanSub(tx.QueryRowContext(ctx, insert, id, ins.Service, ins.Price, ins.UserID, ins.StartDate, ins.EndDate, overlap == model.OverlapAllow))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// the id is taken, replace that row instead over the range already merged for the insert
+		merged := func(old model.SubscriptionDB) (model.SubscriptionDB, error) {
+			next, err := replace(old)
+			next.StartDate, next.EndDate = ins.StartDate, ins.EndDate
+			return next, err
+		}
+		replaced, err := mutateLocked(ctx, tx, id, owner, model.Precondition{}, model.HistoryUpdate, "", merged, overlap)
+		if err != nil {
+			return model.SubscriptionDB{}, false, err
+		}
+		if err := tx.Commit(); err != nil {
+			return model.SubscriptionDB{}, false, overlapErr(err)
+		}
+		return replaced, false, nil
+	case err != nil:
+		return model.SubscriptionDB{}, false, overlapErr(err)
+	}
+
+	if err := recordChange(ctx, tx, model.HistoryCreate, id, nil, &created); err != nil {
+		return model.SubscriptionDB{}, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return model.SubscriptionDB{}, false, overlapErr(err)
+	}
+	return created, true, nil
+}
+
+// DeleteColumnByID soft-deletes the row, PurgeDeleted removes it for good after the retention
+func (r *PostgresSubs) DeleteColumnByID(ctx context.Context, id int, owner string) (err error) {
+	cond, args := ownerFilter(owner, []any{id})
+	sel := `SELECT ` + subColumns + ` FROM subs_table WHERE id = $1` + cond + notDeleted + ` FOR UPDATE`
+	const q = `UPDATE subs_table SET deleted_at = now(), ` + bumpVersion + ` WHERE id = $1`
+	ctx, span := startSpan(ctx, "DeleteColumnByID", q)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	// the history records the row as it was before the delete
+	old, err := scanSub(tx.QueryRowContext(ctx, sel, args...))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, q, id); err != nil {
+		return err
+	}
+	setRows(span, 1)
+	if err := recordChange(ctx, tx, model.HistoryDelete, id, &old, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *PostgresSubs) DeleteColumnByID(ctx context.Context, id int) error {
-	const q = `DELETE FROM subs_table WHERE id = $1`
-	row, err := r.DB.ExecContext(ctx, q, id)
+// RestoreColumnByID clears deleted_at of a soft-deleted row; restoring into an
+// overlap with a live subscription fails with ErrOverlap
+func (r *PostgresSubs) RestoreColumnByID(ctx context.Context, id int, owner string) (_ model.SubscriptionDB, err error) {
+	cond, args := ownerFilter(owner, []any{id})
+	q := `UPDATE subs_table SET deleted_at = NULL, ` + bumpVersion + ` WHERE id = $1 AND deleted_at IS NOT NULL` + cond +
+		` RETURNING ` + subColumns
+	ctx, span := startSpan(ctx, "RestoreColumnByID", q)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return nil
+		return model.SubscriptionDB{}, err
+	}
+	defer tx.Rollback()
+
+	s, err := scanSub(tx.QueryRowContext(ctx, q, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.SubscriptionDB{}, sql.ErrNoRows
 	}
-	affected, err := row.RowsAffected()
 	if err != nil {
-		return nil
+		return model.SubscriptionDB{}, overlapErr(err)
 	}
-	if affected == 0 {
-		return sql.ErrNoRows
+	setRows(span, 1)
+	if err := recordChange(ctx, tx, model.HistoryRestore, id, nil, &s); err != nil {
+		return model.SubscriptionDB{}, err
 	}
-	return nil
+	if err := tx.Commit(); err != nil {
+		return model.SubscriptionDB{}, overlapErr(err)
+	}
+	return s, nil
+}
+
+// PurgeDeleted hard-deletes the rows soft-deleted before the given time,
+// recording their last values in the history in the same statement
+func (r *PostgresSubs) PurgeDeleted(ctx context.Context, before time.Time) (_ int64, err error) {
+	const q = `
+		WITH purged AS (
+			DELETE FROM subs_table WHERE deleted_at < $1
+			RETURNING id, service, price, user_id, start_date, end_date, paused_from
+		)
+		INSERT INTO subs_history (subscription_id, action, old_values, actor)
+		SELECT id, $2, jsonb_strip_nulls(jsonb_build_object(
+			'service', service,
+			'price', price,
+			'user_id', user_id,
+			'start_date', to_char(start_date, 'MM-YYYY'),
+			'end_date', to_char(end_date, 'MM-YYYY'),
+			'paused_from', to_char(paused_from, 'MM-YYYY')
+		)), $3
+		FROM purged
+	`
+	ctx, span := startSpan(ctx, "PurgeDeleted", q)
+	defer func() { endSpan(span, err) }()
+
+	res, err := r.DB.ExecContext(ctx, q, before, model.HistoryPurge, purgeActor)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	setRows(span, purged)
+	return purged, nil
 }
 
-func (r *PostgresSubs) TotalPriceByPeriod(ctx context.Context, userID, service string, from, to time.Time) (int, error) {
-	const q = `SELECT COALESCE(SUM(price), 0) FROM subs_table WHERE user_id = $1 AND service = $2 AND start_date >= $3 AND (end_date <= $4 OR end_date IS NULL)`
+// PurgeIdempotencyKeys forgets the idempotency keys stored before the given time, a retry
+// with such a key creates a new subscription
+func (r *PostgresSubs) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (_ int64, err error) {
+	const q = `DELETE FROM idempotency_keys WHERE created_at < $1`
+	ctx, span := startSpan(ctx, "PurgeIdempotencyKeys", q)
+	defer func() { endSpan(span, err) }()
+
+	res, err := r.DB.ExecContext(ctx, q, before)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	setRows(span, purged)
+	return purged, nil
+}
+
+// billedUntil is the last month a subscription is billed: its end_date, or the month before
+// paused_from while it is paused. NULL means open-ended, LEAST ignores the NULL operands
+const billedUntil = `LEAST(s.end_date, (s.paused_from - interval '1 month')::date)`
+
+// pausedIn is the condition for a month m covered by a finished pause of the subscription
+const pausedIn = `EXISTS (SELECT 1 FROM subs_pauses p WHERE p.subscription_id = s.id AND p.paused_from <= m AND p.resumed_from > m)`
+
+// billedIn is the condition for a subscription billed in the month m
+const billedIn = `s.start_date <= m AND (` + billedUntil + ` IS NULL OR ` + billedUntil + ` >= m) AND NOT ` + pausedIn
+
+// activeMonths is the number of months a subscription is billed within the window [$1, $2];
+// open-ended subscriptions are counted up to the end of the window
+const activeMonths = `(
+	SELECT COUNT(*) FROM generate_series(GREATEST(s.start_date, $1), LEAST(COALESCE(` + billedUntil + `, $2), $2), interval '1 month') AS m
+	WHERE NOT ` + pausedIn + `
+)`
+
+// periodFilter builds the condition for live subscriptions overlapping [from, to] with optional
+// user and service filters; from and to are always bound as $1 and $2
+func periodFilter(userID, service string, from, to time.Time) (string, []any) {
+	cond := `s.start_date <= $2 AND (` + billedUntil + ` IS NULL OR ` + billedUntil + ` >= $1) AND s.deleted_at IS NULL`
+	args := []any{from, to}
+	if userID != "" {
+		args = append(args, userID)
+		cond += fmt.Sprintf(` AND s.user_id = $%d`, len(args))
+	}
+	if service != "" {
+		args = append(args, service)
+		cond += fmt.Sprintf(` AND s.service = $%d`, len(args))
+	}
+	return cond, args
+}
+
+func (r *PostgresSubs) TotalPriceByPeriod(ctx context.Context, userID, service string, from, to time.Time) (_ int, err error) {
+	cond, args := periodFilter(userID, service, from, to)
+	q := `SELECT COALESCE(SUM(s.price * ` + activeMonths + `), 0)::bigint FROM subs_table s WHERE ` + cond
+	ctx, span := startSpan(ctx, "TotalPriceByPeriod", q)
+	defer func() { endSpan(span, err) }()
+
 	var total int
-	err := r.DB.QueryRowContext(ctx, q, userID, service, from, to).Scan(&total)
+	err = r.DB.QueryRowContext(ctx, q, args...).Scan(&total)
 	if err != nil {
 		return 0, err
 	}
 	return total, nil
 }
 
-func (p *PostgresSubs) ListSubscriptions(ctx context.Context, userID string, limit int, offset int) ([]model.SubscriptionDB, error) {
+func (r *PostgresSubs) TotalPriceBreakdown(ctx context.Context, userID, service, groupBy string, from, to time.Time) (_ []model.PriceBreakdown, err error) {
+	cond, args := periodFilter(userID, service, from, to)
+
+	var q string
+	switch groupBy {
+	case model.GroupByService:
+		q = `SELECT s.service, COALESCE(SUM(s.price * ` + activeMonths + `), 0)::bigint FROM subs_table s WHERE ` + cond + ` GROUP BY s.service ORDER BY s.service`
+	case model.GroupByUser:
+		q = `SELECT s.user_id::text, COALESCE(SUM(s.price * ` + activeMonths + `), 0)::bigint FROM subs_table s WHERE ` + cond + ` GROUP BY s.user_id ORDER BY s.user_id`
+	case model.GroupByMonth:
+		// each month of the window is billed for every subscription active in it
+		q = `
+			SELECT to_char(m, 'MM-YYYY'), COALESCE(SUM(s.price), 0)::bigint
+			FROM generate_series($1::date, $2::date, interval '1 month') AS m
+			LEFT JOIN subs_table s ON ` + billedIn + ` AND ` + cond + `
+			GROUP BY m ORDER BY m
+		`
+	default:
+		return nil, fmt.Errorf("unknown group_by value: %s", groupBy)
+	}
+	ctx, span := startSpan(ctx, "TotalPriceBreakdown", q)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := r.DB.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query price breakdown: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	breakdown := []model.PriceBreakdown{}
+	for rows.Next() {
+		var item model.PriceBreakdown
+		if err := rows.Scan(&item.Key, &item.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan price breakdown: %w", err)
+		}
+		breakdown = append(breakdown, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	setRows(span, int64(len(breakdown)))
+	return breakdown, nil
+}
+
+func (r *PostgresSubs) MonthlySpend(ctx context.Context, userID string, from, to time.Time) (_ []model.MonthlySpend, err error) {
+	const q = `
+		SELECT m::date, COALESCE(SUM(s.price), 0)::bigint, COUNT(s.id)
+		FROM generate_series($1::date, $2::date, interval '1 month') AS m
+		LEFT JOIN subs_table s ON s.user_id = $3 AND ` + billedIn + ` AND s.deleted_at IS NULL
+		GROUP BY m ORDER BY m
+	`
+	ctx, span := startSpan(ctx, "MonthlySpend", q)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := r.DB.QueryContext(ctx, q, from, to, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query monthly spend: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	series := []model.MonthlySpend{}
+	for rows.Next() {
+		var (
+			month time.Time
+			item  model.MonthlySpend
+		)
+		if err := rows.Scan(&month, &item.Total, &item.ActiveCount); err != nil {
+			return nil, fmt.Errorf("failed to scan monthly spend: %w", err)
+		}
+		item.Month = conv.FormatMMYYYY(month)
+		series = append(series, item)
+	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	setRows(span, int64(len(series)))
+	return series, nil
+}
+
+func (p *PostgresSubs) ListSubscriptions(ctx context.Context, userID string, limit int, offset int, includeDeleted bool) (_ []model.SubscriptionDB, err error) {
+	cond := notDeleted
+	if includeDeleted {
+		cond = ""
+	}
 	query := `
-		SELECT id, service, price, user_id, start_date, end_date FROM subs_table WHERE user_id = $1 ORDER BY start_date DESC LIMIT $2 OFFSET $3
+		SELECT ` + subColumns + ` FROM subs_table WHERE user_id = $1` + cond + ` ORDER BY start_date DESC LIMIT $2 OFFSET $3
 	`
+	ctx, span := startSpan(ctx, "ListSubscriptions", query)
+	defer func() { endSpan(span, err) }()
 
 	rows, err := p.DB.QueryContext(ctx, query, userID, limit, offset)
 	if err != nil {
@@ -138,16 +652,7 @@ func (p *PostgresSubs) ListSubscriptions(ctx context.Context, userID string, lim
 	var subscriptions []model.SubscriptionDB
 
 	for rows.Next() {
-		var sub model.SubscriptionDB
-
-		err := rows.Scan(
-			&sub.ID,
-			&sub.Service,
-			&sub.Price,
-			&sub.UserID,
-			&sub.StartDate,
-			&sub.EndDate,
-		)
+		sub, err := scanSub(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan subscription: %w", err)
 		}
@@ -159,15 +664,21 @@ func (p *PostgresSubs) ListSubscriptions(ctx context.Context, userID string, lim
 		return nil, fmt.Errorf("rows iteration error: %w", err)
 	}
 
+	setRows(span, int64(len(subscriptions)))
 	return subscriptions, nil
 }
 
-func (r *PostgresSubs) CountSubscription(ctx context.Context, userID string) (int, error) {
-	const q = `SELECT COUNT(*) FROM subs_table WHERE user_id = $1`
+func (r *PostgresSubs) CountSubscription(ctx context.Context, userID string, includeDeleted bool) (_ int, err error) {
+	q := `SELECT COUNT(*) FROM subs_table WHERE user_id = $1`
+	if !includeDeleted {
+		q += notDeleted
+	}
+	ctx, span := startSpan(ctx, "CountSubscription", q)
+	defer func() { endSpan(span, err) }()
 
 	var count int
 
-	err := r.DB.QueryRowContext(ctx, q, userID).Scan(&count)
+	err = r.DB.QueryRowContext(ctx, q, userID).Scan(&count)
 
 	if err != nil {
 		return 0, errors.Join(errors.New("failed rows counting: "), err)
@@ -175,3 +686,17 @@ func (r *PostgresSubs) CountSubscription(ctx context.Context, userID string) (in
 
 	return count, nil
 }
+
+func (r *PostgresSubs) ActiveStats(ctx context.Context, month time.Time) (_ model.ActiveStats, err error) {
+	const q = `SELECT COUNT(*), COALESCE(SUM(s.price), 0)::bigint FROM subs_table s, (SELECT $1::date AS m) AS stats_month
+		WHERE ` + billedIn + ` AND s.deleted_at IS NULL`
+	ctx, span := startSpan(ctx, "ActiveStats", q)
+	defer func() { endSpan(span, err) }()
+
+	var stats model.ActiveStats
+	err = r.DB.QueryRowContext(ctx, q, month).Scan(&stats.Count, &stats.MRR)
+	if err != nil {
+		return model.ActiveStats{}, fmt.Errorf("failed to count active subscriptions: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"jobProject/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan opens a client span for one repository operation and its main SQL statement
+func startSpan(ctx context.Context, op, statement string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "PostgresSubs."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", op),
+			attribute.String("db.statement", statement),
+		))
+}
+
+func setRows(span trace.Span, rows int64) {
+	span.SetAttributes(attribute.Int64("db.rows", rows))
+}
+
+// endSpan closes the span, a missing row is an expected outcome rather than a failure
+func endSpan(span trace.Span, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		setRows(span, 0)
+	} else {
+		tracing.Fail(span, err)
+	}
+	span.End()
+}
@@ -0,0 +1,161 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jobProject/internal/auth"
+	"jobProject/internal/conv"
+	"jobProject/internal/model"
+	"jobProject/internal/repository"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	maxReasonLen   = 500
+	maxRenewMonths = 120
+)
+
+// currentMonth is the first day of the current month in UTC, the precision of subscription dates
+func currentMonth() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// actionMonth parses the optional MM-YYYY month of an action, the current month by default
+func actionMonth(field string, month *string) (time.Time, error) {
+	if month == nil {
+		return currentMonth(), nil
+	}
+	t, err := conv.ParseMMYYYY(*month)
+	if err != nil {
+		return time.Time{}, errors.Join(ErrValidation, &FieldError{Field: field, Err: ErrBadYearMonth})
+	}
+	return t, nil
+}
+
+// stateConflict reports an action that does not fit the current dates of the subscription
+func stateConflict(format string, args ...any) error {
+	return errors.Join(ErrConflict, fmt.Errorf(format, args...))
+}
+
+// keepPause carries the pause of old over to its edited version next. As for
+// PauseSubscription, the pause must not start before start_date and the subscription
+// must not end before the pause
+func keepPause(old, next model.SubscriptionDB) (model.SubscriptionDB, error) {
+	next.PausedFrom = old.PausedFrom
+	if next.PausedFrom == nil {
+		return next, nil
+	}
+	if next.StartDate.After(*next.PausedFrom) {
+		return model.SubscriptionDB{}, errors.Join(ErrValidation, fieldErr("start_date", "start_date must not be after paused_from"))
+	}
+	if next.EndDate != nil && next.EndDate.Before(*next.PausedFrom) {
+		return model.SubscriptionDB{}, stateConflict("subscription is paused from %s, it cannot end before", conv.FormatMMYYYY(*next.PausedFrom))
+	}
+	return next, nil
+}
+
+// lifecycle validates the common arguments of an action and applies mutate to the locked
+// row, recording the change under action with the reason
+func (uc *SubUsecase) lifecycle(ctx context.Context, id int, pre model.Precondition, action, reason string, mutate repository.Mutation) (model.SubscriptionDB, error) {
+	if id <= 0 {
+		return model.SubscriptionDB{}, errors.Join(ErrValidation, errors.New("id in query must be not less then 0"))
+	}
+	reason = strings.TrimSpace(reason)
+	if utf8.RuneCountInString(reason) > maxReasonLen {
+		return model.SubscriptionDB{}, errors.Join(ErrValidation, fieldErr("reason", fmt.Sprintf("reason must be at most %d chars", maxReasonLen)))
+	}
+
+	updated, err := uc.Repo.ApplyLifecycle(ctx, id, auth.Owner(ctx), pre, action, reason, mutate, uc.Overlap)
+	if err != nil {
+		return model.SubscriptionDB{}, conflict(notFound(err, id))
+	}
+	return updated, nil
+}
+
+// CancelSubscription ends the subscription with the given month, the current one by default.
+// The month may not precede start_date, and a subscription already ending by then is a conflict
+func (uc *SubUsecase) CancelSubscription(ctx context.Context, id int, pre model.Precondition, endDate *string, reason string) (_ model.SubscriptionDB, err error) {
+	ctx, span := startSpan(ctx, "CancelSubscription")
+	defer func() { endSpan(span, err) }()
+
+	end, err := actionMonth("end_date", endDate)
+	if err != nil {
+		return model.SubscriptionDB{}, err
+	}
+	return uc.lifecycle(ctx, id, pre, model.HistoryCancel, reason, func(old model.SubscriptionDB) (model.SubscriptionDB, error) {
+		if end.Before(old.StartDate) {
+			return model.SubscriptionDB{}, errors.Join(ErrValidation, fieldErr("end_date", "end_date must be more then start_date"))
+		}
+		if old.EndDate != nil && !old.EndDate.After(end) {
+			return model.SubscriptionDB{}, stateConflict("subscription already ends in %s", conv.FormatMMYYYY(*old.EndDate))
+		}
+		old.EndDate = &end
+		return old, nil
+	})
+}
+
+// PauseSubscription stops billing the subscription from the given month, the current one by
+// default, until it is resumed
+func (uc *SubUsecase) PauseSubscription(ctx context.Context, id int, pre model.Precondition, from *string, reason string) (_ model.SubscriptionDB, err error) {
+	ctx, span := startSpan(ctx, "PauseSubscription")
+	defer func() { endSpan(span, err) }()
+
+	pausedFrom, err := actionMonth("from", from)
+	if err != nil {
+		return model.SubscriptionDB{}, err
+	}
+	return uc.lifecycle(ctx, id, pre, model.HistoryPause, reason, func(old model.SubscriptionDB) (model.SubscriptionDB, error) {
+		if old.PausedFrom != nil {
+			return model.SubscriptionDB{}, stateConflict("subscription is already paused from %s", conv.FormatMMYYYY(*old.PausedFrom))
+		}
+		if pausedFrom.Before(old.StartDate) {
+			return model.SubscriptionDB{}, errors.Join(ErrValidation, fieldErr("from", "pause must not start before start_date"))
+		}
+		if old.EndDate != nil && old.EndDate.Before(pausedFrom) {
+			return model.SubscriptionDB{}, stateConflict("subscription ends in %s, before the pause", conv.FormatMMYYYY(*old.EndDate))
+		}
+		old.PausedFrom = &pausedFrom
+		return old, nil
+	})
+}
+
+// ResumeSubscription bills a paused subscription again from the current month, the months it
+// was paused stay unbilled
+func (uc *SubUsecase) ResumeSubscription(ctx context.Context, id int, pre model.Precondition, reason string) (_ model.SubscriptionDB, err error) {
+	ctx, span := startSpan(ctx, "ResumeSubscription")
+	defer func() { endSpan(span, err) }()
+
+	return uc.lifecycle(ctx, id, pre, model.HistoryResume, reason, func(old model.SubscriptionDB) (model.SubscriptionDB, error) {
+		if old.PausedFrom == nil {
+			return model.SubscriptionDB{}, stateConflict("subscription is not paused")
+		}
+		old.PausedFrom = nil
+		return old, nil
+	})
+}
+
+// RenewSubscription moves end_date the given number of months later; open-ended
+// subscriptions have nothing to renew
+func (uc *SubUsecase) RenewSubscription(ctx context.Context, id int, pre model.Precondition, months int, reason string) (_ model.SubscriptionDB, err error) {
+	ctx, span := startSpan(ctx, "RenewSubscription")
+	defer func() { endSpan(span, err) }()
+
+	if months < 1 || months > maxRenewMonths {
+		return model.SubscriptionDB{}, errors.Join(ErrValidation, fieldErr("months", fmt.Sprintf("months must be from 1 to %d", maxRenewMonths)))
+	}
+	span.SetAttributes(attribute.Int("subscription.renew_months", months))
+	return uc.lifecycle(ctx, id, pre, model.HistoryRenew, reason, func(old model.SubscriptionDB) (model.SubscriptionDB, error) {
+		if old.EndDate == nil {
+			return model.SubscriptionDB{}, stateConflict("subscription has no end_date, there is nothing to renew")
+		}
+		end := old.EndDate.AddDate(0, months, 0)
+		old.EndDate = &end
+		return old, nil
+	})
+}
@@ -0,0 +1,117 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"jobProject/internal/api"
+	"jobProject/internal/conv"
+	"jobProject/internal/model"
+	"jobProject/internal/patch"
+	"testing"
+	"time"
+)
+
+func TestPatchMutation(t *testing.T) {
+	month := func(s string) *time.Time {
+		m, err := conv.ParseMMYYYY(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &m
+	}
+	old := model.SubscriptionDB{
+		ID:         7,
+		Service:    "Yandex Plus",
+		Price:      400,
+		UserID:     "60601fee-2bf1-4721-ae6f-7636e79a0cba",
+		StartDate:  *month("07-2025"),
+		EndDate:    month("12-2025"),
+		PausedFrom: month("09-2025"),
+		Version:    3,
+	}
+	merge := func(s string) patch.Patch {
+		p, err := patch.ParseMergePatch([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	jsonPatch := func(s string) patch.Patch {
+		p, err := patch.ParseJSONPatch([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	read, err := json.Marshal(api.NewSubscriptionResponse(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		patch      patch.Patch
+		wantStart  *time.Time
+		wantEnd    *time.Time
+		wantPrice  int
+		validation bool
+		conflict   bool
+	}{
+		{name: "merge null clears end_date", patch: merge(`{"end_date":null}`), wantPrice: 400},
+		{name: "remove end_date clears it", patch: jsonPatch(`[{"op":"remove","path":"/end_date"}]`), wantPrice: 400},
+		{name: "replace end_date", patch: jsonPatch(`[{"op":"replace","path":"/end_date","value":"03-2026"}]`), wantEnd: month("03-2026"), wantPrice: 400},
+		{name: "merge keeps other fields", patch: merge(`{"price":500}`), wantEnd: month("12-2025"), wantPrice: 500},
+		{name: "end_date before start_date", patch: merge(`{"end_date":"01-2025"}`), validation: true},
+		{name: "removing a required field", patch: jsonPatch(`[{"op":"remove","path":"/price"}]`), validation: true},
+		{name: "unknown field", patch: merge(`{"color":"red"}`), validation: true},
+		{name: "read sent back unchanged", patch: merge(string(read)), wantEnd: month("12-2025"), wantPrice: 400},
+		{name: "read-only members as read", patch: merge(`{"id":7,"paused_from":"09-2025","price":450}`), wantEnd: month("12-2025"), wantPrice: 450},
+		{name: "testing read-only members", patch: jsonPatch(`[{"op":"test","path":"/paused_from","value":"09-2025"},{"op":"test","path":"/deleted_at","value":null}]`), wantEnd: month("12-2025"), wantPrice: 400},
+		{name: "dropping id", patch: jsonPatch(`[{"op":"remove","path":"/id"}]`), wantEnd: month("12-2025"), wantPrice: 400},
+		{name: "changing id", patch: merge(`{"id":8}`), validation: true},
+		{name: "changing paused_from", patch: merge(`{"paused_from":"10-2025"}`), validation: true},
+		{name: "clearing paused_from", patch: merge(`{"paused_from":null}`), validation: true},
+		{name: "setting deleted_at", patch: merge(`{"deleted_at":"2025-10-01T00:00:00Z"}`), validation: true},
+		{name: "missing path", patch: jsonPatch(`[{"op":"replace","path":"/nope","value":1}]`), validation: true},
+		{name: "failed test", patch: jsonPatch(`[{"op":"test","path":"/price","value":1}]`), conflict: true},
+		{name: "start_date up to the pause", patch: merge(`{"start_date":"09-2025"}`), wantEnd: month("12-2025"), wantPrice: 400, wantStart: month("09-2025")},
+		{name: "start_date after the pause", patch: merge(`{"start_date":"10-2025"}`), validation: true},
+		{name: "end_date with the pause", patch: merge(`{"end_date":"09-2025"}`), wantEnd: month("09-2025"), wantPrice: 400},
+		{name: "end_date before the pause", patch: merge(`{"end_date":"08-2025"}`), conflict: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := patchMutation(context.Background(), old.ID, tt.patch)(old)
+			switch {
+			case tt.validation:
+				if !IsValidationErr(err) {
+					t.Fatalf("want ErrValidation, got %v", err)
+				}
+				return
+			case tt.conflict:
+				if !IsConflictErr(err) {
+					t.Fatalf("want ErrConflict, got %v", err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			wantStart := old.StartDate
+			if tt.wantStart != nil {
+				wantStart = *tt.wantStart
+			}
+			if got.ID != old.ID || got.Price != tt.wantPrice || !got.StartDate.Equal(wantStart) {
+				t.Errorf("got %+v", got)
+			}
+			if (got.EndDate == nil) != (tt.wantEnd == nil) || (got.EndDate != nil && !got.EndDate.Equal(*tt.wantEnd)) {
+				t.Errorf("end_date = %v, want %v", got.EndDate, tt.wantEnd)
+			}
+			if got.PausedFrom == nil || !got.PausedFrom.Equal(*old.PausedFrom) {
+				t.Errorf("paused_from = %v, want it kept", got.PausedFrom)
+			}
+		})
+	}
+}
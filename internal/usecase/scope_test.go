@@ -0,0 +1,49 @@
+package usecase
+
+import (
+	"context"
+	"jobProject/internal/auth"
+	"testing"
+)
+
+func TestScopeUser(t *testing.T) {
+	const (
+		own   = "70601fee-2bf1-4721-ae6f-7636e79a0cbb"
+		other = "60601fee-2bf1-4721-ae6f-7636e79a0cba"
+	)
+	user := auth.WithPrincipal(context.Background(), auth.Principal{Subject: own, Name: own, Method: auth.MethodJWT})
+	admin := auth.WithPrincipal(context.Background(), auth.Principal{Name: "ops", Admin: true, Method: auth.MethodAPIKey})
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		userID    string
+		want      string
+		forbidden bool
+	}{
+		{name: "user without filter sees own rows", ctx: user, userID: "", want: own},
+		{name: "user asking for own rows", ctx: user, userID: own, want: own},
+		{name: "user asking for another user", ctx: user, userID: other, forbidden: true},
+		{name: "admin without filter sees every row", ctx: admin, userID: "", want: ""},
+		{name: "admin asking for any user", ctx: admin, userID: other, want: other},
+		{name: "auth disabled", ctx: context.Background(), userID: other, want: other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := scopeUser(tt.ctx, tt.userID)
+			if tt.forbidden {
+				if !IsForbiddenErr(err) {
+					t.Fatalf("want ErrForbidden, got %q, %v", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("scopeUser() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,62 +1,275 @@
 package usecase
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"jobProject/internal/api"
+	"jobProject/internal/auth"
 	"jobProject/internal/conv"
 	"jobProject/internal/model"
+	"jobProject/internal/patch"
 	"jobProject/internal/repository"
+	"jobProject/internal/tracing"
 	"strings"
 	"time"
 	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	ErrValidation = errors.New("validation error")
 	ErrConflict   = errors.New("conflict error")
+	ErrNotFound   = errors.New("not found error")
+	ErrForbidden  = errors.New("forbidden error")
+	// ErrPrecondition means the subscription changed since the version the client read
+	ErrPrecondition = errors.New("precondition error")
 )
 
-func IsValidationErr(err error) bool { return errors.Is(err, ErrValidation) }
-func IsConflictErr(err error) bool   { return errors.Is(err, ErrConflict) }
+func IsValidationErr(err error) bool   { return errors.Is(err, ErrValidation) }
+func IsConflictErr(err error) bool     { return errors.Is(err, ErrConflict) }
+func IsNotFoundErr(err error) bool     { return errors.Is(err, ErrNotFound) }
+func IsForbiddenErr(err error) bool    { return errors.Is(err, ErrForbidden) }
+func IsPreconditionErr(err error) bool { return errors.Is(err, ErrPrecondition) }
+
+func startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "SubUsecase."+op)
+}
+
+// endSpan closes the span; validation, not found, conflict, forbidden and precondition errors are the
+// client's fault so they are tagged instead of failing the span
+func endSpan(span trace.Span, err error) {
+	switch {
+	case err == nil:
+	case IsValidationErr(err):
+		span.SetAttributes(attribute.String("error.type", "validation"))
+	case IsNotFoundErr(err):
+		span.SetAttributes(attribute.String("error.type", "not_found"))
+	case IsConflictErr(err):
+		span.SetAttributes(attribute.String("error.type", "conflict"))
+	case IsForbiddenErr(err):
+		span.SetAttributes(attribute.String("error.type", "forbidden"))
+	case IsPreconditionErr(err):
+		span.SetAttributes(attribute.String("error.type", "precondition"))
+	default:
+		tracing.Fail(span, err)
+	}
+	span.End()
+}
+
+// notFound translates the repository's sql.ErrNoRows into ErrNotFound
+func notFound(err error, id int) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.Join(ErrNotFound, fmt.Errorf("subscription with id %d not found", id))
+	}
+	return err
+}
+
+// conflict translates repository overlap errors into ErrConflict and a stale version into ErrPrecondition
+func conflict(err error) error {
+	if errors.Is(err, repository.ErrOverlap) || errors.Is(err, repository.ErrIdempotencyMismatch) {
+		return errors.Join(ErrConflict, err)
+	}
+	if errors.Is(err, repository.ErrVersionMismatch) {
+		return errors.Join(ErrPrecondition, err)
+	}
+	return err
+}
+
+// scopeUser resolves the user_id filter of a query for the caller: users confined to
+// their own rows get it filled in and may not ask for somebody else's
+func scopeUser(ctx context.Context, userID string) (string, error) {
+	owner := auth.Owner(ctx)
+	if owner == "" {
+		return userID, nil
+	}
+	if userID == "" {
+		return owner, nil
+	}
+	if userID != owner {
+		return "", errors.Join(ErrForbidden, errors.New("access to subscriptions of another user is forbidden"))
+	}
+	return userID, nil
+}
+
+// requireAdmin rejects callers confined to their own rows
+func requireAdmin(ctx context.Context, what string) error {
+	if auth.Owner(ctx) != "" {
+		return errors.Join(ErrForbidden, fmt.Errorf("%s is only available to admins", what))
+	}
+	return nil
+}
 
 type SubUsecase struct {
-	Repo repository.SubsRepository
+	Repo    repository.SubsRepository
+	Overlap model.OverlapPolicy
 }
 
-func NewSubUsecase(repo repository.SubsRepository) *SubUsecase {
-	return &SubUsecase{Repo: repo}
+func NewSubUsecase(repo repository.SubsRepository, overlap model.OverlapPolicy) *SubUsecase {
+	return &SubUsecase{Repo: repo, Overlap: overlap}
 }
 
-func (uc *SubUsecase) CreateColumnUC(ctx context.Context, s model.Subscription) error {
-	err := validateSubscription(s)
+func ParseOverlapPolicy(s string) (model.OverlapPolicy, error) {
+	switch p := model.OverlapPolicy(s); p {
+	case model.OverlapReject, model.OverlapAllow, model.OverlapMerge:
+		return p, nil
+	case "":
+		return model.OverlapReject, nil
+	default:
+		return "", fmt.Errorf("invalid overlap policy: %s (must be reject, allow, or merge)", s)
+	}
+}
+
+func (uc *SubUsecase) CreateColumnUC(ctx context.Context, s model.Subscription) (_ model.SubscriptionDB, err error) {
+	ctx, span := startSpan(ctx, "CreateColumnUC")
+	defer func() { endSpan(span, err) }()
+
+	if s.UserID, err = scopeOwner(ctx, s.UserID); err != nil {
+		return model.SubscriptionDB{}, err
+	}
+	dbSub, err := prepareCreate(s)
 	if err != nil {
-		return errors.Join(ErrValidation, err)
+		return model.SubscriptionDB{}, err
+	}
+	created, err := uc.Repo.CreateColumn(ctx, dbSub, uc.Overlap)
+	return created, conflict(err)
+}
+
+const maxIdempotencyKeyLen = 255
+
+// CreateColumnIdempotentUC creates the subscription at most once per key; the returned
+// bool reports whether the result was replayed from an earlier request
+func (uc *SubUsecase) CreateColumnIdempotentUC(ctx context.Context, key string, s model.Subscription) (_ model.SubscriptionDB, _ bool, err error) {
+	ctx, span := startSpan(ctx, "CreateColumnIdempotentUC")
+	defer func() { endSpan(span, err) }()
+
+	if strings.TrimSpace(key) == "" || len(key) > maxIdempotencyKeyLen {
+		return model.SubscriptionDB{}, false, errors.Join(ErrValidation, fieldErr("Idempotency-Key", fmt.Sprintf("Idempotency-Key must be 1 to %d chars", maxIdempotencyKeyLen)))
+	}
+	if s.UserID, err = scopeOwner(ctx, s.UserID); err != nil {
+		return model.SubscriptionDB{}, false, err
+	}
+	dbSub, err := prepareCreate(s)
+	if err != nil {
+		return model.SubscriptionDB{}, false, err
+	}
+
+	created, replayed, err := uc.Repo.CreateColumnIdempotent(ctx, key, requestHash(s), dbSub, uc.Overlap)
+	return created, replayed, conflict(err)
+}
+
+// scopeOwner applies scopeUser to the user_id of a request body
+func scopeOwner(ctx context.Context, userID *string) (*string, error) {
+	if auth.Owner(ctx) == "" {
+		return userID, nil
+	}
+	var requested string
+	if userID != nil {
+		requested = *userID
+	}
+	scoped, err := scopeUser(ctx, requested)
+	if err != nil {
+		return nil, err
+	}
+	return &scoped, nil
+}
+
+func prepareCreate(s model.Subscription) (model.SubscriptionDB, error) {
+	err := requiredFields(s)
+	if err != nil {
+		return model.SubscriptionDB{}, errors.Join(ErrValidation, err)
+	}
+	err = validateSubscription(s)
+	if err != nil {
+		return model.SubscriptionDB{}, errors.Join(ErrValidation, err)
 	}
 	dbSub, err := conv.ParsedDates(s)
 	if err != nil {
-		return errors.Join(ErrValidation, err)
+		return model.SubscriptionDB{}, errors.Join(ErrValidation, err)
 	}
-	return uc.Repo.CreateColumn(ctx, dbSub)
+	return dbSub, nil
+}
+
+// requestHash fingerprints the decoded request so formatting differences of a retry do not matter
+func requestHash(s model.Subscription) string {
+	body, _ := json.Marshal(s)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// FieldError is a validation failure caused by a single request field
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string { return e.Err.Error() }
+func (e *FieldError) Unwrap() error { return e.Err }
+
+func fieldErr(field, msg string) *FieldError {
+	return &FieldError{Field: field, Err: errors.New(msg)}
+}
+
+// FieldErrors collects every FieldError wrapped in err, including joined errors
+func FieldErrors(err error) []*FieldError {
+	var fields []*FieldError
+	var walk func(error)
+	walk = func(err error) {
+		if fe, ok := err.(*FieldError); ok {
+			fields = append(fields, fe)
+			return
+		}
+		switch e := err.(type) {
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				walk(inner)
+			}
+		case interface{ Unwrap() error }:
+			walk(e.Unwrap())
+		}
+	}
+	if err != nil {
+		walk(err)
+	}
+	return fields
+}
+
+func requiredFields(s model.Subscription) error {
+	var errs []error
+	if s.Service == nil {
+		errs = append(errs, fieldErr("service", "service is required"))
+	}
+	if s.Price == nil {
+		errs = append(errs, fieldErr("price", "price is required"))
+	}
+	if s.UserID == nil {
+		errs = append(errs, fieldErr("user_id", "user_id is required"))
+	}
+	if s.StartDate == nil {
+		errs = append(errs, fieldErr("start_date", "start_date is required"))
+	}
+	return errors.Join(errs...)
 }
 
 func validateSubscription(s model.Subscription) error {
 	if s.Price != nil && *s.Price < 0 {
-		return errors.New("price must be not less then 0")
+		return fieldErr("price", "price must be not less then 0")
 	}
 	if s.Service != nil && (utf8.RuneCountInString(*s.Service) == 0 || strings.TrimSpace(*s.Service) == "") {
-		return errors.New("service name is empty")
+		return fieldErr("service", "service name is empty")
 	}
 	if s.UserID != nil && utf8.RuneCountInString(*s.UserID) != 36 {
-		return errors.New("validate userID length error, must be 36 chars")
+		return fieldErr("user_id", "validate userID length error, must be 36 chars")
 	}
 	if s.StartDate != nil {
-		err := monthYearValidate(*s.StartDate, s.EndDate)
-		if err != nil {
-			return errors.Join(ErrValidation, err)
-		}
+		return monthYearValidate(*s.StartDate, s.EndDate)
 	}
 	return nil
 }
@@ -66,7 +279,7 @@ var ErrBadYearMonth = errors.New("invalid date format want MM-YYYY")
 func monthYearValidate(start string, end *string) error {
 	StartTime, err := conv.ParseMMYYYY(start)
 	if err != nil {
-		return err
+		return &FieldError{Field: "start_date", Err: ErrBadYearMonth}
 	}
 
 	if end == nil {
@@ -75,99 +288,372 @@ func monthYearValidate(start string, end *string) error {
 
 	EndTime, err := conv.ParseMMYYYY(*end)
 	if err != nil {
-		return err
+		return &FieldError{Field: "end_date", Err: ErrBadYearMonth}
 	}
 	if StartTime.After(EndTime) {
-		return errors.Join(ErrValidation, errors.New("end_date must be more then start_date"))
+		return fieldErr("end_date", "end_date must be more then start_date")
 	}
 	return nil
 }
 
-func (uc *SubUsecase) ReadColumnUC(ctx context.Context, id int) (model.SubscriptionDB, error) {
+// ReadColumnUC returns a live subscription; includeDeleted lets admins read soft-deleted ones too
+func (uc *SubUsecase) ReadColumnUC(ctx context.Context, id int, includeDeleted bool) (_ model.SubscriptionDB, err error) {
+	ctx, span := startSpan(ctx, "ReadColumnUC")
+	defer func() { endSpan(span, err) }()
+
 	if id <= 0 {
 		return model.SubscriptionDB{}, errors.Join(ErrValidation, errors.New("id in query must be not less then 0"))
 	}
-	sub, err := uc.Repo.ReadColumn(ctx, id)
+	if includeDeleted {
+		if err := requireAdmin(ctx, "include_deleted"); err != nil {
+			return model.SubscriptionDB{}, err
+		}
+	}
+	sub, err := uc.Repo.ReadColumn(ctx, id, auth.Owner(ctx), includeDeleted)
 	if err != nil {
-		return model.SubscriptionDB{}, err
+		return model.SubscriptionDB{}, notFound(err, id)
 	}
 	return sub, nil
 }
 
-func (uc *SubUsecase) PatchColumnByID(ctx context.Context, id int, s model.Subscription) error {
-	err := validateSubscription(s)
-	if err != nil {
-		return errors.Join(ErrValidation, err)
+// subscriptionDoc is the JSON document patches are applied to, shaped like the API response
+// so a read can be sent back as a merge patch. Unlike the response it always has end_date and
+// the read-only members, so a JSON Patch can replace or remove end_date and test the others
+type subscriptionDoc struct {
+	ID         int        `json:"id"`
+	Service    string     `json:"service"`
+	Price      int        `json:"price"`
+	UserID     string     `json:"user_id"`
+	StartDate  string     `json:"start_date"`
+	EndDate    *string    `json:"end_date"`
+	PausedFrom *string    `json:"paused_from"`
+	DeletedAt  *time.Time `json:"deleted_at"`
+}
+
+func docOf(s model.SubscriptionDB) subscriptionDoc {
+	doc := subscriptionDoc{
+		ID:        s.ID,
+		Service:   s.Service,
+		Price:     s.Price,
+		UserID:    s.UserID,
+		StartDate: conv.FormatMMYYYY(s.StartDate),
+		DeletedAt: s.DeletedAt,
 	}
-	if s.Service == nil && s.Price == nil && s.UserID == nil && s.StartDate == nil && s.EndDate == nil {
-		return errors.Join(ErrValidation, errors.New("no data to update"))
+	if s.EndDate != nil {
+		end := conv.FormatMMYYYY(*s.EndDate)
+		doc.EndDate = &end
 	}
-	if id <= 0 {
-		return errors.Join(ErrValidation, errors.New("id in query must be not less then 0"))
+	if s.PausedFrom != nil {
+		paused := conv.FormatMMYYYY(*s.PausedFrom)
+		doc.PausedFrom = &paused
 	}
+	return doc
+}
 
-	_, err = uc.Repo.ReadColumn(ctx, id)
+// patchedDoc is a patched subscriptionDoc; the read-only members must stay as they were
+// read, only id may be dropped
+type patchedDoc struct {
+	model.Subscription
+	PausedFrom *string    `json:"paused_from"`
+	DeletedAt  *time.Time `json:"deleted_at"`
+}
+
+// readOnlyChange names the first read-only member the patch changed, "" if none was
+func (d patchedDoc) readOnlyChange(old subscriptionDoc) string {
+	switch {
+	case d.ID != 0 && d.ID != old.ID:
+		return "id"
+	case (d.PausedFrom == nil) != (old.PausedFrom == nil) || (d.PausedFrom != nil && *d.PausedFrom != *old.PausedFrom):
+		return "paused_from"
+	case d.DeletedAt != nil && (old.DeletedAt == nil || !d.DeletedAt.Equal(*old.DeletedAt)):
+		return "deleted_at"
+	}
+	return ""
+}
+
+// PatchColumnByID applies a JSON Merge Patch or JSON Patch to the stored subscription and
+// returns the result. The patched subscription is validated as a whole, so start_date must
+// stay before end_date even when only one of them changes. The patch is refused unless the
+// stored version matches pre, the If-Match of the client
+func (uc *SubUsecase) PatchColumnByID(ctx context.Context, id int, pre model.Precondition, p patch.Patch) (_ model.SubscriptionDB, err error) {
+	ctx, span := startSpan(ctx, "PatchColumnByID")
+	defer func() { endSpan(span, err) }()
+
+	if p == nil || p.Empty() {
+		return model.SubscriptionDB{}, errors.Join(ErrValidation, errors.New("no data to update"))
+	}
+	if id <= 0 {
+		return model.SubscriptionDB{}, errors.Join(ErrValidation, errors.New("id in query must be not less then 0"))
+	}
 
+	span.SetAttributes(attribute.IntSlice("subscription.if_match", pre.Versions))
+	updated, err := uc.Repo.PatchColumnByID(ctx, id, auth.Owner(ctx), pre, patchMutation(ctx, id, p), uc.Overlap)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return errors.Join(errors.New("sub not found: "), err)
+		return model.SubscriptionDB{}, conflict(notFound(err, id))
+	}
+	return updated, nil
+}
+
+// patchMutation applies p to the JSON form of the locked row and validates the outcome
+// like a new subscription; a failed JSON Patch test is a conflict with the stored state
+func patchMutation(ctx context.Context, id int, p patch.Patch) repository.Mutation {
+	return func(old model.SubscriptionDB) (model.SubscriptionDB, error) {
+		current := docOf(old)
+		doc, err := json.Marshal(current)
+		if err != nil {
+			return model.SubscriptionDB{}, err
+		}
+		patched, err := p.Apply(doc)
+		if errors.Is(err, patch.ErrTestFailed) {
+			return model.SubscriptionDB{}, errors.Join(ErrConflict, err)
+		}
+		if err != nil {
+			return model.SubscriptionDB{}, errors.Join(ErrValidation, err)
+		}
+
+		var d patchedDoc
+		dec := json.NewDecoder(bytes.NewReader(patched))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&d); err != nil {
+			return model.SubscriptionDB{}, errors.Join(ErrValidation, fmt.Errorf("patched subscription is invalid: %w", err))
+		}
+		if field := d.readOnlyChange(current); field != "" {
+			return model.SubscriptionDB{}, errors.Join(ErrValidation, fieldErr(field, field+" cannot be changed"))
 		}
-		return err
+
+		next, err := prepareCreate(d.Subscription)
+		if err != nil {
+			return model.SubscriptionDB{}, err
+		}
+		if _, err := scopeUser(ctx, next.UserID); err != nil {
+			return model.SubscriptionDB{}, err
+		}
+		next.ID = id
+		// paused_from is read-only, only pause and resume change it
+		return keepPause(old, next)
 	}
+}
+
+// ReplaceColumnByID overwrites every field of the subscription with s, validated like a
+// new one. With upsert a missing id that once held a subscription is created again; the bool
+// reports whether it was. The stored version must match pre, the If-Match of the client; any
+// If-Match rules the upsert out
+func (uc *SubUsecase) ReplaceColumnByID(ctx context.Context, id int, pre model.Precondition, s model.Subscription, upsert bool) (_ model.SubscriptionDB, _ bool, err error) {
+	ctx, span := startSpan(ctx, "ReplaceColumnByID")
+	defer func() { endSpan(span, err) }()
 
-	if s.Service != nil && strings.TrimSpace(*s.Service) == "" {
-		return errors.Join(ErrValidation, errors.New("service name is empty"))
+	if id <= 0 {
+		return model.SubscriptionDB{}, false, errors.Join(ErrValidation, errors.New("id in query must be not less then 0"))
+	}
+	if s.ID != 0 && s.ID != id {
+		return model.SubscriptionDB{}, false, errors.Join(ErrValidation, fieldErr("id", "id in body does not match the path"))
+	}
+	if s.UserID, err = scopeOwner(ctx, s.UserID); err != nil {
+		return model.SubscriptionDB{}, false, err
 	}
-	err = uc.Repo.PatchColumnByID(ctx, id, s)
+	next, err := prepareCreate(s)
 	if err != nil {
-		return err
+		return model.SubscriptionDB{}, false, err
 	}
-	return nil
+	next.ID = id
+
+	span.SetAttributes(attribute.Bool("subscription.upsert", upsert), attribute.IntSlice("subscription.if_match", pre.Versions))
+	owner := auth.Owner(ctx)
+	replace := func(old model.SubscriptionDB) (model.SubscriptionDB, error) {
+		return keepPause(old, next)
+	}
+	if upsert && !pre.Present {
+		stored, created, err := uc.Repo.UpsertColumnByID(ctx, id, owner, next, replace, uc.Overlap)
+		if errors.Is(err, repository.ErrIDNotIssued) {
+			return model.SubscriptionDB{}, false, errors.Join(ErrValidation, fieldErr("id", "upsert can only recreate a subscription that existed, create new ones with POST"))
+		}
+		if err != nil {
+			return model.SubscriptionDB{}, false, conflict(notFound(err, id))
+		}
+		return stored, created, nil
+	}
+
+	stored, err := uc.Repo.PatchColumnByID(ctx, id, owner, pre, replace, uc.Overlap)
+	if err != nil {
+		return model.SubscriptionDB{}, false, conflict(notFound(err, id))
+	}
+	return stored, false, nil
 }
 
-func (uc *SubUsecase) DeleteColumnByID(ctx context.Context, id int) error {
+func (uc *SubUsecase) DeleteColumnByID(ctx context.Context, id int) (err error) {
+	ctx, span := startSpan(ctx, "DeleteColumnByID")
+	defer func() { endSpan(span, err) }()
+
 	if id <= 0 {
 		return errors.Join(ErrValidation, errors.New("id in query must be not less then 0"))
 	}
-	err := uc.Repo.DeleteColumnByID(ctx, id)
+	err = uc.Repo.DeleteColumnByID(ctx, id, auth.Owner(ctx))
 	if err != nil {
-		return err
+		return notFound(err, id)
 	}
 	return nil
 }
 
-func (uc *SubUsecase) TotalPriceByPeriod(ctx context.Context, userID, service string, from, to time.Time) (int, error) {
-	if userID == "" || service == "" {
-		return 0, errors.Join(ErrValidation, errors.New("user_id and service required"))
+// RestoreColumnByID brings back a soft-deleted subscription that was not purged yet
+func (uc *SubUsecase) RestoreColumnByID(ctx context.Context, id int) (_ model.SubscriptionDB, err error) {
+	ctx, span := startSpan(ctx, "RestoreColumnByID")
+	defer func() { endSpan(span, err) }()
+
+	if id <= 0 {
+		return model.SubscriptionDB{}, errors.Join(ErrValidation, errors.New("id in query must be not less then 0"))
 	}
+	restored, err := uc.Repo.RestoreColumnByID(ctx, id, auth.Owner(ctx))
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.SubscriptionDB{}, errors.Join(ErrNotFound, fmt.Errorf("deleted subscription with id %d not found", id))
+	}
+	return restored, conflict(err)
+}
+
+// SubscriptionHistory pages through the changes of a subscription, oldest first.
+// Callers confined to their own rows only see the history of subscriptions they own,
+// including soft-deleted ones, and only the entries recording their own rows
+func (uc *SubUsecase) SubscriptionHistory(ctx context.Context, id int, params api.PaginationParams) (_ api.HistoryResponse, err error) {
+	ctx, span := startSpan(ctx, "SubscriptionHistory")
+	defer func() { endSpan(span, err) }()
+
+	if id <= 0 {
+		return api.HistoryResponse{}, errors.Join(ErrValidation, errors.New("id in query must be not less then 0"))
+	}
+	// subscriptions created before the history was recorded exist without entries
+	owner := auth.Owner(ctx)
+	_, err = uc.Repo.ReadColumn(ctx, id, owner, true)
+	exists := err == nil
+	if err != nil && (owner != "" || !errors.Is(err, sql.ErrNoRows)) {
+		return api.HistoryResponse{}, notFound(err, id)
+	}
+
+	params.Validate()
+
+	total, err := uc.Repo.CountHistory(ctx, id, owner)
+	if err != nil {
+		return api.HistoryResponse{}, err
+	}
+	// the history of a purged subscription stays readable for admins
+	if !exists && total == 0 {
+		return api.HistoryResponse{}, notFound(sql.ErrNoRows, id)
+	}
+	entries, err := uc.Repo.ListHistory(ctx, id, owner, params.Limit, params.GetOffset())
+	if err != nil {
+		return api.HistoryResponse{}, err
+	}
+
+	return api.HistoryResponse{
+		Data: api.NewHistoryList(entries),
+		Pagination: api.PaginationMeta{
+			Page:       params.Page,
+			Limit:      params.Limit,
+			Total:      total,
+			TotalPages: (total + params.Limit - 1) / params.Limit,
+		},
+	}, nil
+}
+
+// PurgeDeleted hard-deletes subscriptions soft-deleted longer than retention ago
+func (uc *SubUsecase) PurgeDeleted(ctx context.Context, retention time.Duration) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "PurgeDeleted")
+	defer func() { endSpan(span, err) }()
+
+	return uc.Repo.PurgeDeleted(ctx, time.Now().Add(-retention))
+}
+
+// PurgeIdempotencyKeys forgets idempotency keys older than ttl
+func (uc *SubUsecase) PurgeIdempotencyKeys(ctx context.Context, ttl time.Duration) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "PurgeIdempotencyKeys")
+	defer func() { endSpan(span, err) }()
+
+	return uc.Repo.PurgeIdempotencyKeys(ctx, time.Now().Add(-ttl))
+}
+
+func (uc *SubUsecase) TotalPriceByPeriod(ctx context.Context, userID, service, groupBy string, from, to time.Time) (_ api.TotalPriceResponse, err error) {
+	ctx, span := startSpan(ctx, "TotalPriceByPeriod")
+	defer func() { endSpan(span, err) }()
+
 	if from.After(to) {
-		return 0, errors.Join(ErrValidation, errors.New("error perion end_date must be later then start_date"))
+		return api.TotalPriceResponse{}, errors.Join(ErrValidation, errors.New("error perion end_date must be later then start_date"))
+	}
+	switch groupBy {
+	case "", model.GroupByService, model.GroupByUser, model.GroupByMonth:
+	default:
+		return api.TotalPriceResponse{}, errors.Join(ErrValidation, fieldErr("group_by", "group_by must be one of service, user, month"))
+	}
+	userID, err = scopeUser(ctx, userID)
+	if err != nil {
+		return api.TotalPriceResponse{}, err
 	}
 
 	total, err := uc.Repo.TotalPriceByPeriod(ctx, userID, service, from, to)
 	if err != nil {
-		return 0, err
+		return api.TotalPriceResponse{}, err
 	}
-	return total, nil
+	response := api.TotalPriceResponse{Total: total}
+
+	if groupBy != "" {
+		breakdown, err := uc.Repo.TotalPriceBreakdown(ctx, userID, service, groupBy, from, to)
+		if err != nil {
+			return api.TotalPriceResponse{}, err
+		}
+		response.Breakdown = breakdown
+	}
+	return response, nil
+}
+
+const maxSeriesMonths = 120
+
+func (uc *SubUsecase) MonthlySpend(ctx context.Context, userID string, from, to time.Time) (_ []model.MonthlySpend, err error) {
+	ctx, span := startSpan(ctx, "MonthlySpend")
+	defer func() { endSpan(span, err) }()
+
+	if userID == "" {
+		return nil, errors.Join(ErrValidation, fieldErr("user_id", "user_id is required"))
+	}
+	if userID, err = scopeUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	if from.After(to) {
+		return nil, errors.Join(ErrValidation, errors.New("error perion end_date must be later then start_date"))
+	}
+	months := (to.Year()-from.Year())*12 + int(to.Month()-from.Month()) + 1
+	if months > maxSeriesMonths {
+		return nil, errors.Join(ErrValidation, fmt.Errorf("period must not exceed %d months", maxSeriesMonths))
+	}
+
+	return uc.Repo.MonthlySpend(ctx, userID, from, to)
 }
 
 func (r *SubUsecase) ListSubscriptions(
 	ctx context.Context,
 	userID string,
 	params api.PaginationParams,
-) (api.PaginatedResponse, error) {
+	includeDeleted bool,
+) (_ api.PaginatedResponse, err error) {
+	ctx, span := startSpan(ctx, "ListSubscriptions")
+	defer func() { endSpan(span, err) }()
+
 	if userID == "" {
 		return api.PaginatedResponse{}, errors.New("user_id is required")
 	}
+	if userID, err = scopeUser(ctx, userID); err != nil {
+		return api.PaginatedResponse{}, err
+	}
+	if includeDeleted {
+		if err := requireAdmin(ctx, "include_deleted"); err != nil {
+			return api.PaginatedResponse{}, err
+		}
+	}
 
 	params.Validate()
 
-	subscriptions, err := r.Repo.ListSubscriptions(ctx, userID, params.Limit, params.GetOffset())
+	subscriptions, err := r.Repo.ListSubscriptions(ctx, userID, params.Limit, params.GetOffset(), includeDeleted)
 	if err != nil {
 		return api.PaginatedResponse{}, fmt.Errorf("failed to list subscriptions: %w", err)
 	}
 
-	total, err := r.Repo.CountSubscription(ctx, userID)
+	total, err := r.Repo.CountSubscription(ctx, userID, includeDeleted)
 	if err != nil {
 		return api.PaginatedResponse{}, fmt.Errorf("failed to count subscriptions: %w", err)
 	}
@@ -178,7 +664,7 @@ func (r *SubUsecase) ListSubscriptions(
 	}
 
 	response := api.PaginatedResponse{
-		Data: subscriptions,
+		Data: api.NewSubscriptionList(subscriptions),
 		Pagination: api.PaginationMeta{
 			Page:       params.Page,
 			Limit:      params.Limit,
@@ -189,3 +675,11 @@ func (r *SubUsecase) ListSubscriptions(
 
 	return response, nil
 }
+
+// ActiveStats reports the subscriptions active in the current month and their total price
+func (uc *SubUsecase) ActiveStats(ctx context.Context) (_ model.ActiveStats, err error) {
+	ctx, span := startSpan(ctx, "ActiveStats")
+	defer func() { endSpan(span, err) }()
+
+	return uc.Repo.ActiveStats(ctx, currentMonth())
+}
@@ -0,0 +1,82 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"jobProject/internal/api"
+	"jobProject/internal/auth"
+	"jobProject/internal/db"
+	"jobProject/internal/model"
+	"jobProject/internal/repository"
+	"os"
+	"testing"
+)
+
+// TestUpsertPurgedForeignID recreates a purged subscription under another user: the id and
+// its history stay with the user who owned it
+func TestUpsertPurgedForeignID(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ctx := context.Background()
+	if _, err := db.MigrateUp(ctx, conn); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	uc := NewSubUsecase(&repository.PostgresSubs{DB: conn}, model.OverlapReject)
+
+	const (
+		userA = "70601fee-2bf1-4721-ae6f-7636e79a0cbb"
+		userB = "60601fee-2bf1-4721-ae6f-7636e79a0cba"
+	)
+	asA := auth.WithPrincipal(ctx, auth.Principal{Subject: userA, Name: userA, Method: auth.MethodJWT})
+	asB := auth.WithPrincipal(ctx, auth.Principal{Subject: userB, Name: userB, Method: auth.MethodJWT})
+	sub := func(service string) model.Subscription {
+		price, start := 300, "01-2025"
+		return model.Subscription{Service: &service, Price: &price, StartDate: &start}
+	}
+
+	created, err := uc.CreateColumnUC(asA, sub("Upsert purged foreign id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := created.ID
+	t.Cleanup(func() {
+		if _, err := conn.ExecContext(ctx, `DELETE FROM subs_history WHERE subscription_id = $1`, id); err != nil {
+			t.Errorf("cleanup history: %v", err)
+		}
+		if _, err := conn.ExecContext(ctx, `DELETE FROM subs_table WHERE id = $1`, id); err != nil {
+			t.Errorf("cleanup subscription: %v", err)
+		}
+	})
+	if err := uc.DeleteColumnByID(asA, id); err != nil {
+		t.Fatal(err)
+	}
+	// the purge leaves only the history behind
+	if _, err := conn.ExecContext(ctx, `DELETE FROM subs_table WHERE id = $1`, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := uc.ReplaceColumnByID(asB, id, model.Precondition{}, sub("Taken over"), true); !IsNotFoundErr(err) {
+		t.Fatalf("upsert by another user: got %v, want not found", err)
+	}
+	if _, err := uc.SubscriptionHistory(asB, id, api.PaginationParams{}); !IsNotFoundErr(err) {
+		t.Fatalf("history for another user: got %v, want not found", err)
+	}
+
+	if _, created, err := uc.ReplaceColumnByID(asA, id, model.Precondition{}, sub("Upsert purged foreign id"), true); err != nil || !created {
+		t.Fatalf("upsert by the owner: created %v, err %v", created, err)
+	}
+	history, err := uc.SubscriptionHistory(asA, id, api.PaginationParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Pagination.Total != 3 {
+		t.Errorf("owner sees %d history entries, want create, delete and create", history.Pagination.Total)
+	}
+}
@@ -1,55 +1,242 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
+	"fmt"
+	"jobProject/internal/auth"
+	"jobProject/internal/config"
 	"jobProject/internal/db"
 	"jobProject/internal/handlers"
 	"jobProject/internal/logger"
+	"jobProject/internal/metrics"
+	"jobProject/internal/ratelimit"
 	"jobProject/internal/repository"
+	"jobProject/internal/tracing"
 	"jobProject/internal/usecase"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	httpSwagger "github.com/swaggo/http-swagger"
+	"gopkg.in/yaml.v2"
 )
 
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT (HS256 или RS256) в виде "Bearer <token>"
 func main() {
-	logLevel := os.Getenv("LOG_LEVEL")
-	if logLevel == "" {
-		logLevel = "INFO"
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file, environment variables override it")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		os.Exit(1)
 	}
 
-	slog.Info("Starting application", "log_level", logLevel)
+	if *printConfig {
+		out, err := yaml.Marshal(cfg.Redacted())
+		if err != nil {
+			slog.Error("Failed to print configuration", "error", err)
+			os.Exit(1)
+		}
+		fmt.Print(string(out))
+		return
+	}
+
+	logger.InitLogger(cfg.Logging.Level)
+
+	// exitCode is set by failures after start-up; it is applied last, once the deferred
+	// cleanup below has flushed the traces
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
 
-	logger.InitLogger(logLevel)
+	slog.Info("Starting application", "log_level", cfg.Logging.Level)
 
-	if err := db.InitDB(); err != nil {
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Error("Failed to initialize tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("Failed to flush traces", "error", err)
+		}
+	}()
+
+	if err := db.InitDB(cfg.Database); err != nil {
 		slog.Error("Failed to initialize database", "error", err)
 		os.Exit(1)
 	}
 	slog.Info("Database initialized successfully")
 
+	if args := flag.Args(); len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(context.Background(), args[1:]); err != nil {
+			slog.Error("Migration failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cfg.Database.AutoMigrate {
+		applied, err := db.MigrateUp(context.Background(), db.DB)
+		if err != nil {
+			slog.Error("Failed to apply migrations", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Migrations applied", "count", applied)
+	}
+
 	subRepo := &repository.PostgresSubs{DB: db.DB}
-	subUC := usecase.NewSubUsecase(subRepo)
+	overlap, err := usecase.ParseOverlapPolicy(cfg.Subscriptions.OverlapPolicy)
+	if err != nil {
+		slog.Error("Failed to read overlap policy", "error", err)
+		os.Exit(1)
+	}
+	subUC := usecase.NewSubUsecase(subRepo, overlap)
 
 	if err := handlers.Init(subUC); err != nil {
 		slog.Error("Failed to initialize handlers", "error", err)
 		os.Exit(1)
 	}
+	if err := handlers.InitHealth(db.DB); err != nil {
+		slog.Error("Failed to initialize health checks", "error", err)
+		os.Exit(1)
+	}
+	if cfg.Auth.Enabled {
+		authn, err := auth.New(cfg.Auth)
+		if err != nil {
+			slog.Error("Failed to initialize authentication", "error", err)
+			os.Exit(1)
+		}
+		handlers.InitAuth(authn)
+	} else {
+		slog.Warn("Authentication is disabled, every caller can access all subscriptions")
+	}
+	if cfg.RateLimit.Enabled {
+		handlers.InitRateLimit(ratelimit.New(cfg.RateLimit), cfg.RateLimit.TrustProxy)
+	}
 	slog.Info("Handlers initialized successfully")
 
-	http.HandleFunc("/CreateColumn", handlers.CreateColumn)
-	http.HandleFunc("/ReadSubByID", handlers.ReadSubByID)
-	http.HandleFunc("/PatchColumnByID", handlers.PatchColumnByID)
-	http.HandleFunc("/DeleteColumnByID", handlers.DeleteColumnByID)
-	http.HandleFunc("/TotalPriceByPeriod", handlers.TotalPriceByPeriod)
-	http.HandleFunc("/swagger/", httpSwagger.WrapHandler)
-	http.HandleFunc("/ListSubscriptions", handlers.ListSubscriptions)
+	if err := metrics.RegisterDB(db.DB); err != nil {
+		slog.Error("Failed to register database metrics", "error", err)
+		os.Exit(1)
+	}
+	if err := metrics.RegisterBusiness(subUC); err != nil {
+		slog.Error("Failed to register business metrics", "error", err)
+		os.Exit(1)
+	}
+
+	mux := http.NewServeMux()
+
+	// api authenticates the caller first so the rate limit is keyed by identity
+	api := func(h http.HandlerFunc) http.HandlerFunc {
+		return handlers.RequireAuth(handlers.RateLimit(h))
+	}
+
+	mux.HandleFunc("POST /api/v1/subscriptions", api(handlers.CreateColumn))
+	mux.HandleFunc("GET /api/v1/subscriptions", api(handlers.ListSubscriptions))
+	mux.HandleFunc("GET /api/v1/subscriptions/total", api(handlers.TotalPriceByPeriod))
+	mux.HandleFunc("GET /api/v1/subscriptions/monthly-spend", api(handlers.MonthlySpend))
+	mux.HandleFunc("GET /api/v1/subscriptions/{id}", api(handlers.ReadSubByID))
+	mux.HandleFunc("PATCH /api/v1/subscriptions/{id}", api(handlers.PatchColumnByID))
+	mux.HandleFunc("PUT /api/v1/subscriptions/{id}", api(handlers.ReplaceSubByID))
+	mux.HandleFunc("DELETE /api/v1/subscriptions/{id}", api(handlers.DeleteColumnByID))
+	mux.HandleFunc("POST /api/v1/subscriptions/{id}/restore", api(handlers.RestoreSubByID))
+	mux.HandleFunc("POST /api/v1/subscriptions/{id}/cancel", api(handlers.CancelSubByID))
+	mux.HandleFunc("POST /api/v1/subscriptions/{id}/pause", api(handlers.PauseSubByID))
+	mux.HandleFunc("POST /api/v1/subscriptions/{id}/resume", api(handlers.ResumeSubByID))
+	mux.HandleFunc("POST /api/v1/subscriptions/{id}/renew", api(handlers.RenewSubByID))
+	mux.HandleFunc("GET /api/v1/subscriptions/{id}/history", api(handlers.SubscriptionHistory))
+
+	// deprecated RPC-style aliases
+	mux.HandleFunc("/CreateColumn", handlers.Deprecated("/api/v1/subscriptions", api(handlers.CreateColumn)))
+	mux.HandleFunc("/ReadSubByID", handlers.Deprecated("/api/v1/subscriptions/{id}", api(handlers.ReadSubByID)))
+	mux.HandleFunc("/PatchColumnByID", handlers.Deprecated("/api/v1/subscriptions/{id}", api(handlers.PatchColumnByID)))
+	mux.HandleFunc("/DeleteColumnByID", handlers.Deprecated("/api/v1/subscriptions/{id}", api(handlers.DeleteColumnByID)))
+	mux.HandleFunc("/TotalPriceByPeriod", handlers.Deprecated("/api/v1/subscriptions/total", api(handlers.TotalPriceByPeriod)))
+	mux.HandleFunc("/MonthlySpend", handlers.Deprecated("/api/v1/subscriptions/monthly-spend", api(handlers.MonthlySpend)))
+	mux.HandleFunc("/ListSubscriptions", handlers.Deprecated("/api/v1/subscriptions", api(handlers.ListSubscriptions)))
+
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.HandleFunc("GET /healthz", handlers.Healthz)
+	mux.HandleFunc("GET /readyz", handlers.Readyz)
+
+	mux.HandleFunc("/swagger/", httpSwagger.WrapHandler)
+
+	server := &http.Server{
+		Addr:         ":" + cfg.Server.Port,
+		Handler:      handlers.RequestID(handlers.Tracing(handlers.AccessLog(handlers.Metrics(handlers.JSONErrors(mux))))),
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	purgeCtx, stopPurge := context.WithCancel(ctx)
+	defer stopPurge()
+	var purging sync.WaitGroup
+	if cfg.Subscriptions.DeletedRetention > 0 || cfg.Subscriptions.IdempotencyTTL > 0 {
+		purging.Go(func() { runPurge(purgeCtx, subUC, cfg.Subscriptions) })
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+	handlers.SetReady(true)
+
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			slog.Error("Server failed", "error", err)
+			exitCode = 1
+		}
+	case <-ctx.Done():
+		slog.Info("Shutdown signal received, draining connections",
+			"drain", cfg.Server.ShutdownDrain,
+			"timeout", cfg.Server.ShutdownTimeout)
+	}
+
+	handlers.SetReady(false)
+	if exitCode == 0 && cfg.Server.ShutdownDrain > 0 {
+		// keep serving while load balancers notice the failing readiness probe
+		time.Sleep(cfg.Server.ShutdownDrain)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Server did not shut down cleanly", "error", err)
+	}
+
+	stopPurge()
+	purging.Wait()
 
-	log.Println("listening on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("server error: %v", err)
+	if err := db.DB.Close(); err != nil {
+		slog.Error("Failed to close database", "error", err)
 	}
+	slog.Info("Server stopped")
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"jobProject/internal/db"
+	"strconv"
+)
+
+// runMigrate implements `migrate up|down [steps]|status`
+func runMigrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [steps]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := db.MigrateUp(ctx, db.DB)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migration(s)\n", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a positive integer, got %q", args[1])
+			}
+		}
+		n, err := db.MigrateDown(ctx, db.DB, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rolled back %d migration(s)\n", n)
+	case "status":
+		status, err := db.Migrations(ctx, db.DB)
+		if err != nil {
+			return err
+		}
+		for _, m := range status {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = "applied " + m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, want up, down or status", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"jobProject/internal/config"
+	"jobProject/internal/usecase"
+	"log/slog"
+	"time"
+)
+
+// runPurge hard-deletes subscriptions soft-deleted longer than the retention ago and forgets
+// expired idempotency keys, once at start and then every interval until ctx is cancelled;
+// a zero retention or ttl turns its part off
+func runPurge(ctx context.Context, uc *usecase.SubUsecase, cfg config.SubscriptionsConfig) {
+	ticker := time.NewTicker(cfg.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if cfg.DeletedRetention > 0 {
+			purged, err := uc.PurgeDeleted(ctx, cfg.DeletedRetention)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Error("Failed to purge deleted subscriptions", "error", err)
+			} else if purged > 0 {
+				slog.Info("Purged deleted subscriptions",
+					"count", purged,
+					"retention", cfg.DeletedRetention)
+			}
+		}
+
+		if cfg.IdempotencyTTL > 0 {
+			purged, err := uc.PurgeIdempotencyKeys(ctx, cfg.IdempotencyTTL)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Error("Failed to purge idempotency keys", "error", err)
+			} else if purged > 0 {
+				slog.Info("Purged expired idempotency keys",
+					"count", purged,
+					"ttl", cfg.IdempotencyTTL)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}